@@ -0,0 +1,670 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/lock"
+	"2pc-sim/pkg/metrics"
+	"2pc-sim/pkg/node"
+	"2pc-sim/pkg/partition"
+	"2pc-sim/pkg/protocol"
+	"2pc-sim/pkg/sim"
+	"2pc-sim/pkg/transport"
+	"2pc-sim/pkg/wal"
+	"2pc-sim/pkg/workload"
+
+	"github.com/google/uuid"
+)
+
+// participantNode is what the runner needs from a 2PC or 3PC participant
+type participantNode interface {
+	Start()
+	CurrentState() protocol.State
+}
+
+// coordinatorNode is what the runner needs from a 2PC or 3PC coordinator
+type coordinatorNode interface {
+	Start()
+	Begin() *node.Txn
+}
+
+// opsCoordinator is a coordinator that can run transactions with operations
+type opsCoordinator interface {
+	BeginOps(ops []protocol.Operation) *node.Txn
+}
+
+func main() {
+	var (
+		numParticipants int
+		latencyMs       int
+		dropRate        float64
+		voteNoRate      float64
+		timeoutSec      int
+		jitter          float64
+		retryInterval   int
+		walDir          string
+		fsyncMs         float64
+		crashNode       string
+		crashPhase      string
+		crashDowntimeMs int
+		inquiryMs       int
+		cooperative     bool
+		protocolName    string
+		modeName        string
+		readOnlyRate    float64
+		numTxns         int
+		numKeys         int
+		deadlockName    string
+		lockTimeoutMs   int
+		partitioning    string
+		txnKeys         int
+		onePhase        bool
+		concurrency     int
+		arrivalRate     float64
+		txnParticipants int
+		readRatio       float64
+		zipf            float64
+		outputFormat    string
+		deterministic   bool
+		seed            int64
+		outFile         string
+		faultSpec       string
+		topologyFile    string
+		latencyDist     string
+	)
+
+	flag.IntVar(&numParticipants, "participants", 3, "Number of participants")
+	flag.IntVar(&latencyMs, "latency", 10, "Average network latency in ms")
+	flag.Float64Var(&dropRate, "drop-rate", 0.0, "Packet drop rate (0.0 - 1.0)")
+	flag.Float64Var(&voteNoRate, "abort-rate", 0.0, "Probability of a participant voting No on a transaction (0.0 - 1.0)")
+	flag.IntVar(&timeoutSec, "timeout", 5, "Transaction timeout in seconds")
+	flag.Float64Var(&jitter, "jitter", 0.2, "Network jitter (0.0 - 1.0)")
+	flag.IntVar(&retryInterval, "retry-interval", 500, "Retry interval in ms")
+	flag.StringVar(&walDir, "wal-dir", "", "Directory for file-backed write-ahead logs (default: in-memory logs)")
+	flag.Float64Var(&fsyncMs, "fsync-latency", 0, "Simulated cost of each forced log write in ms")
+	flag.StringVar(&crashNode, "crash-node", "", "ID of a node to crash during the transaction (e.g. p-0 or coordinator)")
+	flag.StringVar(&crashPhase, "crash-phase", "ready", "Phase at which the node crashes: prepare, ready or decision")
+	flag.IntVar(&crashDowntimeMs, "crash-downtime", 1000, "Time a crashed node stays down before recovering, in ms")
+	flag.IntVar(&inquiryMs, "inquiry-interval", 1000, "How long a Ready participant waits before asking the coordinator for the decision, in ms (0 disables)")
+	flag.BoolVar(&cooperative, "cooperative", false, "Let blocked participants ask their peers for the decision when the coordinator is silent")
+	flag.StringVar(&protocolName, "protocol", "2pc", "Commit protocol to run: 2pc or 3pc")
+	flag.StringVar(&modeName, "mode", "standard", "2PC variant: standard, presumed-abort or presumed-commit")
+	flag.Float64Var(&readOnlyRate, "read-only-rate", 0.0, "Fraction of participants that only read (0.0 - 1.0)")
+	flag.IntVar(&numTxns, "transactions", 1, "Total number of transactions to run")
+	flag.IntVar(&concurrency, "concurrency", 0, "Closed-loop clients, each starting a transaction when its last one finished (0: start all at once)")
+	flag.Float64Var(&arrivalRate, "rate", 0, "Open-loop Poisson arrival rate in transactions per second (0: closed loop)")
+	flag.IntVar(&numKeys, "keys", 0, "Size of the key space; when set, transactions run operations on random keys (0: no data)")
+	flag.StringVar(&deadlockName, "deadlock", "no-wait", "What a transaction does when a lock is taken: no-wait, timeout, wait-die, wound-wait or detect")
+	flag.IntVar(&lockTimeoutMs, "lock-timeout", 100, "How long a transaction waits for a lock under -deadlock timeout, in ms")
+	flag.StringVar(&partitioning, "partitioning", "hash", "How keys map to participants: hash (consistent hash ring) or range (contiguous key ranges)")
+	flag.IntVar(&txnKeys, "txn-keys", 0, "Operations in each transaction (0: one per participant)")
+	flag.IntVar(&txnParticipants, "txn-participants", 0, "Participants each transaction touches (0: wherever its keys fall)")
+	flag.Float64Var(&readRatio, "read-ratio", 0, "Fraction of operations that are reads (0.0 - 1.0)")
+	flag.Float64Var(&zipf, "zipf", 0, "Zipfian key skew exponent, greater than 1 (0: uniform)")
+	flag.BoolVar(&onePhase, "one-phase", true, "Commit transactions that touch a single participant with one message instead of 2PC")
+	flag.BoolVar(&deterministic, "deterministic", false, "Run as a discrete-event simulation on a virtual clock, replayable from -seed")
+	flag.Int64Var(&seed, "seed", 0, "Seed for every random choice (0: pick one and print it)")
+	flag.StringVar(&outputFormat, "output", "", "Also write the settings, per-transaction records and summary as json or csv")
+	flag.StringVar(&outFile, "out", "", "File for -output (default: results.json or results.csv; - for stdout)")
+	flag.StringVar(&faultSpec, "faults", "", "Link faults to inject over the run, e.g. \"15ms isolate coordinator; 3s heal\" (see README)")
+	flag.StringVar(&topologyFile, "topology", "", "JSON file giving links between nodes or regions their own latency, jitter and drop rate (see README)")
+	flag.StringVar(&latencyDist, "latency-dist", "", "Distribution of message delays around -latency: uniform, exponential, normal, lognormal, pareto or empirical:<histogram file> (default: uniform with -jitter)")
+	flag.Parse()
+
+	if protocolName != "2pc" && protocolName != "3pc" {
+		log.Fatalf("Unknown protocol %q (want 2pc or 3pc)", protocolName)
+	}
+	if protocolName == "3pc" && crashNode != "" {
+		log.Fatal("Crash injection is only supported with -protocol 2pc")
+	}
+
+	crashPoint, err := node.ParseCrashPoint(crashPhase)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mode, err := node.ParseCommitMode(modeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if protocolName == "3pc" && readOnlyRate > 0 {
+		log.Fatal("The read-only optimization is only supported with -protocol 2pc")
+	}
+	if protocolName == "3pc" && numKeys > 0 {
+		log.Fatal("Transaction data (-keys) is only supported with -protocol 2pc")
+	}
+	if numKeys > 0 && readOnlyRate > 0 {
+		// With data, a participant is read-only when its share only reads
+		log.Fatal("-read-only-rate cannot be combined with -keys; use -read-ratio")
+	}
+	policy, err := lock.ParsePolicy(deadlockName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if partitioning != "hash" && partitioning != "range" {
+		log.Fatalf("Unknown partitioning %q (want hash or range)", partitioning)
+	}
+	if txnKeys == 0 {
+		txnKeys = numParticipants
+		if txnParticipants > txnKeys {
+			txnKeys = txnParticipants
+		}
+	}
+	if protocolName == "3pc" && mode != node.ModeStandard {
+		log.Fatal("Presumed abort/commit modes are only supported with -protocol 2pc")
+	}
+	if deterministic && fsyncMs > 0 {
+		// A forced write sleeps in real time, which a virtual clock cannot see
+		log.Fatal("-fsync-latency cannot be combined with -deterministic")
+	}
+	if outputFormat != "" && outputFormat != "json" && outputFormat != "csv" {
+		log.Fatalf("Unknown output format %q (want json or csv)", outputFormat)
+	}
+	if outFile == "" {
+		outFile = "results." + outputFormat
+	}
+	if numTxns < 1 {
+		log.Fatal("-transactions must be at least 1")
+	}
+	crashDowntime := time.Duration(crashDowntimeMs) * time.Millisecond
+	faults, err := transport.ParseFaults(faultSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var dist transport.Distribution
+	if latencyDist != "" {
+		if dist, err = transport.ParseDistribution(latencyDist, jitter); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var topology *transport.Topology
+	if topologyFile != "" {
+		if topology, err = transport.LoadTopology(topologyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	// Under -deterministic a seeded scheduler owns the clock, and transaction
+	// IDs come from the seed too, since lock priorities are broken by ID
+	var clk clock.Clock = clock.Real{}
+	var sched *sim.Scheduler
+	if deterministic {
+		sched = sim.NewScheduler(seed)
+		clk = sched
+		uuid.SetRand(rand.New(rand.NewSource(seed)))
+	}
+
+	fmt.Printf("--- Commit Simulation Configuration ---\n")
+	fmt.Printf("Protocol: %s\n", protocolName)
+	if protocolName == "2pc" {
+		fmt.Printf("Mode: %s\n", mode)
+	}
+	fmt.Printf("Participants: %d\n", numParticipants)
+	fmt.Printf("Transactions: %d\n", numTxns)
+	switch {
+	case arrivalRate > 0:
+		fmt.Printf("Arrivals: open loop, %.1f tx/s\n", arrivalRate)
+	case concurrency > 0 && concurrency < numTxns:
+		fmt.Printf("Arrivals: closed loop, %d clients\n", concurrency)
+	}
+	if numKeys > 0 {
+		fmt.Printf("Keys: %d\n", numKeys)
+		fmt.Printf("Partitioning: %s\n", partitioning)
+		fmt.Printf("Transaction Size: %d operations, %.0f%% reads", txnKeys, readRatio*100)
+		if txnParticipants > 0 {
+			fmt.Printf(", %d participants", txnParticipants)
+		}
+		fmt.Println()
+		if zipf > 0 {
+			fmt.Printf("Key Skew: zipf %.2f\n", zipf)
+		} else {
+			fmt.Printf("Key Skew: uniform\n")
+		}
+		fmt.Printf("Deadlock Policy: %s\n", policy)
+	}
+	fmt.Printf("Latency: %d ms\n", latencyMs)
+	if dist != nil {
+		fmt.Printf("Latency Distribution: %s\n", dist)
+	}
+	if topology != nil {
+		fmt.Printf("Topology: %s (%d regions, %d links)\n", topologyFile, len(topology.Regions), len(topology.Links))
+	}
+	fmt.Printf("Drop Rate: %.2f\n", dropRate)
+	fmt.Printf("Abort Rate: %.2f\n", voteNoRate)
+	fmt.Printf("Read-Only Rate: %.2f\n", readOnlyRate)
+	fmt.Printf("Timeout: %d s\n", timeoutSec)
+	fmt.Printf("Fsync Latency: %.2f ms\n", fsyncMs)
+	if crashNode != "" {
+		fmt.Printf("Crash: %s at %s, down for %v\n", crashNode, crashPoint, crashDowntime)
+	}
+	for _, f := range faults {
+		fmt.Printf("Fault: %s\n", f)
+	}
+	if deterministic {
+		fmt.Printf("Clock: virtual (discrete-event)\n")
+	}
+	fmt.Printf("Seed: %d\n", seed)
+	fmt.Println("------------------------------------")
+
+	// Initialize Network
+	net := transport.NewSimulatedNetwork(time.Duration(latencyMs)*time.Millisecond, dropRate, jitter)
+	net.Dist = dist
+	net.Clock = clk
+	net.Seed(seed)
+
+	// Initialize write-ahead logs
+	syncDelay := time.Duration(fsyncMs * float64(time.Millisecond))
+	var logs []wal.Log
+	openLog := func(id string) wal.Log {
+		var l wal.Log = wal.NewMemoryLog(syncDelay)
+		if walDir != "" {
+			fl, err := wal.OpenFileLog(filepath.Join(walDir, id+".wal"), syncDelay)
+			if err != nil {
+				log.Fatalf("Failed to open log for %s: %v", id, err)
+			}
+			l = fl
+		}
+		logs = append(logs, l)
+		return l
+	}
+	if walDir != "" {
+		if err := os.MkdirAll(walDir, 0o755); err != nil {
+			log.Fatalf("Failed to create wal dir: %v", err)
+		}
+	}
+
+	// Initialize Participants
+	var pIDs []string
+	participants := make([]participantNode, numParticipants)
+	var lockManagers []*lock.Manager
+	detector := lock.NewDetector()
+	detector.Clock = clk
+	timeout := time.Duration(timeoutSec) * time.Second
+	// The last numReadOnly participants only read
+	numReadOnly := int(math.Round(readOnlyRate * float64(numParticipants)))
+	coordID := "coordinator"
+
+	// Time each transaction spent blocked in Ready, one entry per participant
+	var blockingMu sync.Mutex
+	blocking := make(map[uuid.UUID][]time.Duration)
+	for i := 0; i < numParticipants; i++ {
+		pID := fmt.Sprintf("p-%d", i)
+		pIDs = append(pIDs, pID)
+
+		// Each participant votes No on a share of the transactions; its
+		// own seed keeps the draws the same from run to run
+		voteNoSeed := rng.Int63()
+
+		if protocolName == "3pc" {
+			// Participants outwait the coordinator's vote timeout, so that they
+			// do not ask each other while it is still collecting votes
+			p := node.NewParticipant3PC(pID, net, coordID, 2*timeout)
+			p.Clock = clk
+			p.Log = openLog(pID)
+			p.VoteNoRate = voteNoRate
+			p.Seed(voteNoSeed)
+			participants[i] = p
+			p.Start()
+			continue
+		}
+
+		p := node.NewParticipant(pID, net, coordID)
+		p.Clock = clk
+		p.Locks.Clock = clk
+		p.Log = openLog(pID)
+		p.InquiryInterval = time.Duration(inquiryMs) * time.Millisecond
+		p.Cooperative = cooperative
+		p.Mode = mode
+		p.OnBlocked = func(txID uuid.UUID, d time.Duration) {
+			blockingMu.Lock()
+			defer blockingMu.Unlock()
+			blocking[txID] = append(blocking[txID], d)
+		}
+
+		p.VoteNoRate = voteNoRate
+		p.Seed(voteNoSeed)
+		p.ReadOnly = i >= numParticipants-numReadOnly
+		p.Locks.Policy = policy
+		p.LockTimeout = time.Duration(lockTimeoutMs) * time.Millisecond
+		if policy == lock.Detect {
+			detector.Register(p.Locks)
+		}
+		lockManagers = append(lockManagers, p.Locks)
+
+		if pID == crashNode {
+			p.CrashPoint = crashPoint
+			p.CrashDowntime = crashDowntime
+		}
+
+		participants[i] = p
+		p.Start()
+	}
+
+	// Place the keys on the participants
+	keys := keyNames(numKeys)
+	owner := partition.NewRing(pIDs, partition.DefaultReplicas).Owner
+	if partitioning == "range" {
+		owner = partition.SplitEvenly(pIDs, keys).Owner
+	}
+	gen, err := workload.NewGenerator(workload.Config{
+		Transactions: numTxns,
+		Concurrency:  concurrency,
+		Rate:         arrivalRate,
+		Keys:         numKeys,
+		Ops:          txnKeys,
+		Participants: txnParticipants,
+		ReadRatio:    readRatio,
+		Zipf:         zipf,
+		Seed:         rng.Int63(),
+	}, keys, owner)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Initialize Coordinator
+	var coord coordinatorNode
+	var coord2PC *node.Coordinator
+	if protocolName == "3pc" {
+		c := node.NewCoordinator3PC(coordID, net, pIDs, timeout, time.Duration(retryInterval)*time.Millisecond)
+		c.Log = openLog(coordID)
+		c.Clock = clk
+		coord = c
+	} else {
+		coord2PC = node.NewCoordinator(coordID, net, pIDs, timeout, time.Duration(retryInterval)*time.Millisecond)
+		coord2PC.Log = openLog(coordID)
+		coord2PC.Mode = mode
+		coord2PC.OnePhase = onePhase
+		coord2PC.Owner = owner
+		coord2PC.Clock = clk
+		if crashNode == coordID {
+			coord2PC.CrashPoint = crashPoint
+			coord2PC.CrashDowntime = crashDowntime
+		}
+		coord = coord2PC
+	}
+	coord.Start()
+
+	if topology != nil {
+		if err := topology.Apply(net, append([]string{coordID}, pIDs...)); err != nil {
+			log.Fatalf("Topology %s: %v", topologyFile, err)
+		}
+	}
+
+	if sched == nil {
+		// Wait a bit for initialization
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Run the workload
+	if numTxns == 1 {
+		fmt.Println("\n>>> Starting Transaction <<<")
+	} else {
+		fmt.Printf("\n>>> Starting %d Transactions <<<\n", numTxns)
+	}
+	begin := func(ops []protocol.Operation) *node.Txn {
+		if ops == nil {
+			return coord.Begin()
+		}
+		return coord.(opsCoordinator).BeginOps(ops)
+	}
+	if err := net.Schedule(faults); err != nil {
+		log.Fatal(err)
+	}
+	wallStart := time.Now()
+	start := clk.Now()
+	var txns []*node.Txn
+	if sched != nil {
+		txns = gen.RunOn(sched, begin)
+		for _, tx := range txns {
+			select {
+			case <-tx.Done():
+			default:
+				log.Fatalf("Simulation ran out of events with Tx %s unfinished", tx.ID)
+			}
+		}
+	} else {
+		txns = gen.Run(begin)
+	}
+	committed := make(map[*node.Txn]bool)
+	latency := make(map[*node.Txn]time.Duration)
+	for _, tx := range txns {
+		committed[tx], latency[tx] = tx.Wait()
+	}
+	duration := clk.Since(start)
+
+	// Let in-flight decisions land (unacknowledged ones are not waited for)
+	settle := time.Duration(2*latencyMs) * time.Millisecond
+	if crashNode != "" {
+		// Give the crashed node time to come back and settle its in-doubt
+		// transactions; a restarted coordinator aborts the undecided ones
+		settle += crashDowntime + time.Duration(2*latencyMs)*time.Millisecond
+	}
+	if sched != nil {
+		sched.RunFor(settle)
+	} else {
+		time.Sleep(settle)
+	}
+
+	fmt.Println("\n--- Results ---")
+	numCommitted := 0
+	for _, tx := range txns {
+		if committed[tx] {
+			numCommitted++
+		}
+	}
+	if numTxns == 1 {
+		status := "COMMITTED"
+		if numCommitted == 0 {
+			status = "ABORTED"
+		}
+		fmt.Printf("Transaction Status: %s\n", status)
+	} else {
+		fmt.Printf("Transactions Committed: %d / %d\n", numCommitted, numTxns)
+	}
+	if sched != nil {
+		fmt.Printf("Total Duration: %v (virtual, simulated in %v)\n", duration, time.Since(wallStart))
+	} else {
+		fmt.Printf("Total Duration: %v\n", duration)
+	}
+
+	// Per-transaction latency, phases, retries and drops
+	recorder := metrics.NewRecorder()
+	droppedByTx := net.Stats().DroppedByTx
+	// Participants still in Ready never closed their window: count what they
+	// had waited by the end apart from the finished ones
+	unresolved := make(map[uuid.UUID][]time.Duration)
+	for _, p := range participants {
+		if p2pc, ok := p.(*node.Participant); ok {
+			for txID, d := range p2pc.StillBlocked() {
+				unresolved[txID] = append(unresolved[txID], d)
+			}
+		}
+	}
+	blockingMu.Lock()
+	for _, tx := range txns {
+		recorder.Record(metrics.Sample{
+			ID:           tx.ID.String(),
+			Participants: len(tx.Participants),
+			OnePhase:     tx.OnePhase,
+			Latency:      latency[tx],
+			Committed:    committed[tx],
+			Phases:       map[string]time.Duration{"voting": tx.Voting, "acking": tx.Acking},
+			Retries:      tx.Retries,
+			Drops:        droppedByTx[tx.ID],
+			Blocking:     blocking[tx.ID],
+			Unresolved:   unresolved[tx.ID],
+		})
+	}
+	blockingMu.Unlock()
+	report := recorder.Report(duration)
+	report.Print(os.Stdout)
+	if numKeys > 0 {
+		single, touched := 0, 0
+		for _, tx := range txns {
+			touched += len(tx.Participants)
+			if len(tx.Participants) == 1 {
+				single++
+			}
+		}
+		fmt.Printf("Single-Partition Transactions: %d / %d\n", single, numTxns)
+		fmt.Printf("Participants per Transaction: %.2f\n", float64(touched)/float64(numTxns))
+	}
+
+	// One-phase commit: how often it fired and what it saved over 2PC
+	var onePhaseTxns, twoPhaseTxns int
+	var onePhaseTime, twoPhaseTime time.Duration
+	for _, tx := range txns {
+		if tx.OnePhase {
+			onePhaseTxns++
+			onePhaseTime += latency[tx]
+		} else {
+			twoPhaseTxns++
+			twoPhaseTime += latency[tx]
+		}
+	}
+	if onePhaseTxns > 0 {
+		fmt.Printf("One-Phase Transactions: %d / %d (avg latency %v)\n", onePhaseTxns, numTxns, onePhaseTime/time.Duration(onePhaseTxns))
+		if twoPhaseTxns > 0 {
+			avg2PC := twoPhaseTime / time.Duration(twoPhaseTxns)
+			fmt.Printf("2PC Transactions: %d (avg latency %v, %v saved per one-phase transaction)\n", twoPhaseTxns, avg2PC, avg2PC-onePhaseTime/time.Duration(onePhaseTxns))
+		}
+	}
+
+	var regionOf map[string]string
+	if topology != nil {
+		regionOf = topology.RegionOf()
+	}
+	for i, p := range participants {
+		name := pIDs[i]
+		if region, ok := regionOf[name]; ok {
+			name += " (" + region + ")"
+		}
+		state := p.CurrentState()
+		// With links of their own, participants block for different lengths
+		// of time: the near ones wait on the far ones
+		detail := ""
+		if p2pc, ok := p.(*node.Participant); ok && topology != nil {
+			if n, total := p2pc.BlockingTime(); n > 0 {
+				detail = fmt.Sprintf(", avg %v in Ready", total/time.Duration(n))
+			}
+		}
+		if state == protocol.StateReady {
+			fmt.Printf("Participant %s: %s (still blocked)%s\n", name, state, detail)
+		} else {
+			fmt.Printf("Participant %s: %s%s\n", name, state, detail)
+		}
+	}
+
+	// Logging overhead, summed over every node
+	var forces int
+	var syncTime time.Duration
+	for _, l := range logs {
+		stats := l.Stats()
+		forces += stats.Forces
+		syncTime += stats.SyncTime
+		l.Close()
+	}
+	fmt.Printf("Forced Log Writes: %d\n", forces)
+	fmt.Printf("Total Sync Time: %v\n", syncTime)
+
+	// Lock contention, summed over every participant
+	if numKeys > 0 {
+		var lockStats lock.Stats
+		for _, m := range lockManagers {
+			s := m.Stats()
+			lockStats.Conflicts += s.Conflicts
+			lockStats.Waits += s.Waits
+			lockStats.WaitTime += s.WaitTime
+			lockStats.Aborts += s.Aborts
+			lockStats.Holds += s.Holds
+			lockStats.HoldTime += s.HoldTime
+			if s.MaxHold > lockStats.MaxHold {
+				lockStats.MaxHold = s.MaxHold
+			}
+		}
+		fmt.Printf("Lock Conflicts: %d\n", lockStats.Conflicts)
+		if lockStats.Waits > 0 {
+			fmt.Printf("Lock Waits: %d (total wait %v)\n", lockStats.Waits, lockStats.WaitTime)
+		}
+		// Every one of these became a VoteNo
+		fmt.Printf("Lock Aborts (%s): %d\n", policy, lockStats.Aborts)
+		if policy == lock.Detect {
+			fmt.Printf("Deadlocks Detected: %d\n", detector.Deadlocks())
+		}
+		if lockStats.Holds > 0 {
+			fmt.Printf("Lock Hold Time: avg %v, max %v\n", lockStats.HoldTime/time.Duration(lockStats.Holds), lockStats.MaxHold)
+		}
+	}
+
+	netStats := net.Stats()
+	if len(faults) > 0 {
+		fmt.Printf("Messages Sent: %d (dropped %d, blocked %d)\n", netStats.Sent, netStats.Dropped, netStats.Blocked)
+	} else {
+		fmt.Printf("Messages Sent: %d (dropped %d)\n", netStats.Sent, netStats.Dropped)
+	}
+	var types []protocol.MessageType
+	for t := range netStats.ByType {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, t := range types {
+		fmt.Printf("  %-20s %d\n", t, netStats.ByType[t])
+	}
+
+	if outputFormat != "" {
+		config := make(map[string]string)
+		flag.VisitAll(func(f *flag.Flag) {
+			// Where the results go does not describe the run, and would spoil diffs
+			if f.Name != "out" {
+				config[f.Name] = f.Value.String()
+			}
+		})
+		if err := writeResults(outFile, outputFormat, metrics.NewResults(config, recorder.Samples(), report)); err != nil {
+			log.Fatalf("Failed to write results: %v", err)
+		}
+		if outFile != "-" {
+			fmt.Printf("Results written to %s\n", outFile)
+		}
+	}
+}
+
+// writeResults writes res to path, or to stdout when path is "-"
+func writeResults(path, format string, res metrics.Results) error {
+	if path == "-" {
+		return res.Write(os.Stdout, format)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := res.Write(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// keyNames returns the key space in order. Keys are zero-padded so that their
+// string order, which range partitioning uses, matches their numeric order.
+func keyNames(n int) []string {
+	width := len(fmt.Sprint(n))
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%0*d", width, i)
+	}
+	return keys
+}
@@ -0,0 +1,141 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs functions later. Nodes and the network take
+// every reading and every timer from one, so that a simulation can swap the
+// wall clock for a virtual one.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	// AfterFunc calls f once d has elapsed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending AfterFunc call
+type Timer interface {
+	// Stop cancels the call; it reports false if f already ran or was stopped
+	Stop() bool
+}
+
+// Real is the wall clock
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (Real) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Fake is a clock that only moves when told to. Tests use it to fire timers
+// exactly when they want instead of sleeping past them.
+type Fake struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+	seq    int
+}
+
+type fakeTimer struct {
+	clock *Fake
+	at    time.Time
+	seq   int
+	f     func()
+}
+
+// NewFake creates a fake clock reading start
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	t := &fakeTimer{clock: f, at: f.now.Add(d), seq: f.seq, f: fn}
+	f.timers = append(f.timers, t)
+	f.cond.Broadcast()
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d. Every timer that falls due runs on
+// the caller's goroutine, in deadline order, with the clock reading its
+// deadline; timers they set that fall due within d run too.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	for {
+		sort.SliceStable(f.timers, func(i, j int) bool {
+			if !f.timers[i].at.Equal(f.timers[j].at) {
+				return f.timers[i].at.Before(f.timers[j].at)
+			}
+			return f.timers[i].seq < f.timers[j].seq
+		})
+		if len(f.timers) == 0 || f.timers[0].at.After(end) {
+			break
+		}
+		t := f.timers[0]
+		f.timers = f.timers[1:]
+		if t.at.After(f.now) {
+			f.now = t.at
+		}
+		f.mu.Unlock()
+		t.f()
+		f.mu.Lock()
+	}
+	f.now = end
+	f.mu.Unlock()
+}
+
+// Pending returns the number of timers that have not fired or been stopped
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// BlockUntil waits until at least n timers are pending, for tests whose timers
+// are set by another goroutine
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.timers) < n {
+		f.cond.Wait()
+	}
+}
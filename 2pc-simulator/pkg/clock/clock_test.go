@@ -0,0 +1,67 @@
+package clock
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFakeAdvanceRunsDueTimersInOrder(t *testing.T) {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	var order []string
+	at := make(map[string]time.Duration)
+	record := func(name string) func() {
+		return func() {
+			order = append(order, name)
+			at[name] = f.Since(start)
+		}
+	}
+	f.AfterFunc(30*time.Millisecond, record("c"))
+	f.AfterFunc(10*time.Millisecond, func() {
+		record("a")()
+		// Set from a timer, relative to its deadline
+		f.AfterFunc(5*time.Millisecond, record("b"))
+	})
+	f.AfterFunc(time.Second, record("late"))
+
+	f.Advance(30 * time.Millisecond)
+	if fmt.Sprint(order) != "[a b c]" {
+		t.Errorf("Expected [a b c], got %v", order)
+	}
+	if at["a"] != 10*time.Millisecond || at["b"] != 15*time.Millisecond || at["c"] != 30*time.Millisecond {
+		t.Errorf("Timers should see the clock at their deadlines, got %v", at)
+	}
+	if f.Pending() != 1 {
+		t.Errorf("Expected the late timer to be pending, got %d", f.Pending())
+	}
+}
+
+func TestFakeStop(t *testing.T) {
+	f := NewFake(time.Time{})
+	ran := false
+	timer := f.AfterFunc(time.Millisecond, func() { ran = true })
+	if !timer.Stop() {
+		t.Error("Stopping a pending timer should succeed")
+	}
+	if timer.Stop() {
+		t.Error("Stopping it twice should fail")
+	}
+	f.Advance(time.Second)
+	if ran {
+		t.Error("A stopped timer should not run")
+	}
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	f := NewFake(time.Time{})
+	fired := make(chan struct{})
+	go func() {
+		f.AfterFunc(time.Minute, func() { close(fired) })
+	}()
+
+	// The goroutine sets its timer whenever it gets to run
+	f.BlockUntil(1)
+	f.Advance(time.Minute)
+	<-fired
+}
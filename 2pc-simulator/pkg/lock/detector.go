@@ -0,0 +1,151 @@
+package lock
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
+)
+
+// Detector looks for deadlocks in the waits-for graph formed by every Manager
+// registered with it. A transaction waiting at one participant for a
+// transaction that waits at another shows up as a cycle only here, never in
+// a single manager. It stands in for the global detector a real system runs
+// as a service, which is why looking at the managers costs no messages.
+type Detector struct {
+	// Clock runs the victims' OnVictim hooks
+	Clock    clock.Clock
+	mu       sync.Mutex
+	managers []*Manager
+	victims  map[uuid.UUID]bool // chosen but not yet aborted
+	found    int
+}
+
+func NewDetector() *Detector {
+	return &Detector{Clock: clock.Real{}, victims: make(map[uuid.UUID]bool)}
+}
+
+// Register adds m to the graph the detector watches
+func (d *Detector) Register(m *Manager) {
+	d.mu.Lock()
+	d.managers = append(d.managers, m)
+	d.mu.Unlock()
+	m.mu.Lock()
+	m.detector = d
+	m.mu.Unlock()
+}
+
+// Deadlocks returns how many cycles the detector has broken
+func (d *Detector) Deadlocks() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.found
+}
+
+// Check searches the graph for cycles and breaks each one by aborting its
+// youngest transaction: every manager it waits at has its OnVictim hook called
+func (d *Detector) Check() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	edges := make(map[uuid.UUID][]uuid.UUID)
+	ts := make(map[uuid.UUID]int64)
+	waitsAt := make(map[uuid.UUID][]*Manager)
+	for _, m := range d.managers {
+		e, t := m.waitsFor()
+		for txID, blockers := range e {
+			edges[txID] = append(edges[txID], blockers...)
+			waitsAt[txID] = append(waitsAt[txID], m)
+		}
+		for txID, stamp := range t {
+			ts[txID] = stamp
+		}
+	}
+	for txID := range d.victims {
+		if _, waiting := edges[txID]; !waiting {
+			delete(d.victims, txID)
+		}
+	}
+
+	for _, cycle := range cycles(edges, d.victims) {
+		victim := cycle[0]
+		for _, txID := range cycle[1:] {
+			if ts[txID] > ts[victim] || (ts[txID] == ts[victim] && txID.String() > victim.String()) {
+				victim = txID
+			}
+		}
+		d.victims[victim] = true
+		d.found++
+		for _, m := range waitsAt[victim] {
+			if m.OnVictim != nil {
+				// The hook takes the participant's lock, which the caller may hold
+				hook := m.OnVictim
+				d.Clock.AfterFunc(0, func() { hook(victim) })
+			}
+		}
+	}
+}
+
+// cycles returns one cycle for each group of deadlocked transactions, leaving
+// out cycles through a transaction that is already being aborted
+func cycles(edges map[uuid.UUID][]uuid.UUID, skip map[uuid.UUID]bool) [][]uuid.UUID {
+	const (
+		unvisited = iota
+		onPath
+		finished
+	)
+	state := make(map[uuid.UUID]int)
+	for txID := range skip {
+		state[txID] = finished
+	}
+
+	var found [][]uuid.UUID
+	var path []uuid.UUID
+	var visit func(txID uuid.UUID) bool
+	visit = func(txID uuid.UUID) bool {
+		state[txID] = onPath
+		path = append(path, txID)
+		for _, next := range edges[txID] {
+			switch state[next] {
+			case onPath:
+				for i, id := range path {
+					if id == next {
+						found = append(found, append([]uuid.UUID(nil), path[i:]...))
+						break
+					}
+				}
+				// One victim per cycle is enough; stop walking this tree
+				return true
+			case unvisited:
+				if visit(next) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[txID] = finished
+		return false
+	}
+
+	// Start in ID order, so that the same graph always yields the same cycles
+	var start []uuid.UUID
+	for txID := range edges {
+		start = append(start, txID)
+	}
+	sort.Slice(start, func(i, j int) bool { return start[i].String() < start[j].String() })
+	for _, txID := range start {
+		if state[txID] == unvisited {
+			if visit(txID) {
+				// Whatever is still on the path waits on the cycle; it is
+				// released once the victim aborts
+				for _, id := range path {
+					state[id] = finished
+				}
+				path = path[:0]
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,49 @@
+package lock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDetectorAbortsYoungestInCycle(t *testing.T) {
+	d := NewDetector()
+	m1, m2 := NewManager(), NewManager()
+	victims := make(chan string, 2)
+	for name, m := range map[string]*Manager{"m1": m1, "m2": m2} {
+		name := name
+		m.Policy = Detect
+		m.OnVictim = func(txID uuid.UUID) { victims <- name + ":" + txID.String() }
+		d.Register(m)
+	}
+
+	older, younger := uuid.New(), uuid.New()
+	m1.Acquire(older, 1, "a", Exclusive)
+	m2.Acquire(younger, 2, "b", Exclusive)
+	if err := m2.Acquire(older, 1, "b", Exclusive); err != ErrWait {
+		t.Fatalf("Expected ErrWait, got %v", err)
+	}
+	if d.Deadlocks() != 0 {
+		t.Fatal("A single wait is not a deadlock")
+	}
+	m1.Acquire(younger, 2, "a", Exclusive)
+
+	select {
+	case v := <-victims:
+		if v != "m1:"+younger.String() {
+			t.Errorf("Expected the younger transaction to be aborted where it waits, got %s", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Detector did not pick a victim")
+	}
+	if d.Deadlocks() != 1 {
+		t.Errorf("Expected 1 deadlock, got %d", d.Deadlocks())
+	}
+
+	// Until the victim is gone the same cycle is not broken twice
+	d.Check()
+	if d.Deadlocks() != 1 {
+		t.Errorf("A pending victim should not be chosen again, got %d deadlocks", d.Deadlocks())
+	}
+}
@@ -0,0 +1,417 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
+)
+
+// Mode is the strength of a lock
+type Mode int
+
+const (
+	Shared Mode = iota
+	Exclusive
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Shared:
+		return "Shared"
+	case Exclusive:
+		return "Exclusive"
+	default:
+		return "Unknown"
+	}
+}
+
+// Policy decides what happens to a request that conflicts with a held lock
+type Policy int
+
+const (
+	// NoWait fails the request at once
+	NoWait Policy = iota
+	// WaitTimeout queues the request; the caller gives up after a timeout
+	WaitTimeout
+	// WaitDie lets a transaction wait only for younger ones; a younger
+	// requester dies (fails) instead
+	WaitDie
+	// WoundWait lets an older requester wound (abort) younger blockers,
+	// while a younger requester waits
+	WoundWait
+	// Detect queues every request and lets a Detector break cycles in the
+	// global waits-for graph
+	Detect
+)
+
+func (p Policy) String() string {
+	switch p {
+	case NoWait:
+		return "no-wait"
+	case WaitTimeout:
+		return "timeout"
+	case WaitDie:
+		return "wait-die"
+	case WoundWait:
+		return "wound-wait"
+	case Detect:
+		return "detect"
+	default:
+		return "unknown"
+	}
+}
+
+// ParsePolicy converts a CLI policy name into a Policy
+func ParsePolicy(s string) (Policy, error) {
+	for _, p := range []Policy{NoWait, WaitTimeout, WaitDie, WoundWait, Detect} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return NoWait, fmt.Errorf("unknown deadlock policy %q (want no-wait, timeout, wait-die, wound-wait or detect)", s)
+}
+
+// ErrWait is returned when a request has been queued. The manager's OnGrant
+// hook is called once it is granted.
+var ErrWait = errors.New("lock request queued")
+
+// ConflictError is returned when a request is refused and the requesting
+// transaction must abort
+type ConflictError struct {
+	Key     string
+	Mode    Mode
+	Holders []uuid.UUID
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s lock on %q conflicts with %d holder(s)", e.Mode, e.Key, len(e.Holders))
+}
+
+// Stats summarises the locking done by a Manager
+type Stats struct {
+	Acquired  int
+	Conflicts int
+	// Waits counts queued requests and WaitTime the time granted ones spent queued
+	Waits    int
+	WaitTime time.Duration
+	// Aborts counts transactions the policy made abort: refused, wounded,
+	// timed out or chosen as deadlock victims
+	Aborts int
+	// Holds counts transactions that released their locks, HoldTime is the
+	// total time they held them and MaxHold the longest single hold
+	Holds    int
+	HoldTime time.Duration
+	MaxHold  time.Duration
+}
+
+// request is a queued lock request
+type request struct {
+	txID  uuid.UUID
+	mode  Mode
+	since time.Time
+}
+
+// lockState is who holds one key, how, and who is waiting for it
+type lockState struct {
+	mode    Mode
+	holders map[uuid.UUID]bool
+	queue   []*request
+}
+
+// grantable reports whether r can be granted alongside the current holders
+func (l *lockState) grantable(r *request) bool {
+	if len(l.holders) == 0 {
+		return true
+	}
+	if len(l.holders) == 1 && l.holders[r.txID] {
+		// Upgrade by the only holder
+		return true
+	}
+	return r.mode == Shared && l.mode == Shared
+}
+
+// Manager hands out shared and exclusive locks on keys. A transaction runs
+// its operations one at a time, so it waits for at most one lock.
+type Manager struct {
+	Policy Policy
+	// OnGrant is called when a queued request is granted, and OnVictim when a
+	// Detector picks a waiting transaction to abort. OnGrant runs on the
+	// goroutine that released the lock, after the manager's own mutex has
+	// been released; OnVictim runs on a goroutine of its own.
+	OnGrant  func(txID uuid.UUID)
+	OnVictim func(txID uuid.UUID)
+	// Clock times waits and holds
+	Clock    clock.Clock
+	mu       sync.Mutex
+	locks    map[string]*lockState
+	held     map[uuid.UUID][]string  // keys locked by each transaction
+	since    map[uuid.UUID]time.Time // when each transaction took its first lock
+	waiting  map[uuid.UUID]string    // key each waiting transaction is queued on
+	ts       map[uuid.UUID]int64     // timestamp of each transaction, for its age
+	detector *Detector
+	stats    Stats
+}
+
+// NewManager creates a lock manager with no locks held
+func NewManager() *Manager {
+	return &Manager{
+		Clock:   clock.Real{},
+		locks:   make(map[string]*lockState),
+		held:    make(map[uuid.UUID][]string),
+		since:   make(map[uuid.UUID]time.Time),
+		waiting: make(map[uuid.UUID]string),
+		ts:      make(map[uuid.UUID]int64),
+	}
+}
+
+// older reports whether transaction a started before b; ties are broken by ID
+func (m *Manager) older(a, b uuid.UUID) bool {
+	if m.ts[a] != m.ts[b] {
+		return m.ts[a] < m.ts[b]
+	}
+	return a.String() < b.String()
+}
+
+// Acquire locks key for txID in mode. ts orders transactions by age for
+// wait-die and wound-wait. Re-acquiring a held lock succeeds, and a shared
+// lock is upgraded when txID is its only holder. It returns nil when the lock
+// is granted, ErrWait when the request was queued, and a *ConflictError when
+// txID must abort.
+func (m *Manager) Acquire(txID uuid.UUID, ts int64, key string, mode Mode) error {
+	m.mu.Lock()
+	if _, ok := m.ts[txID]; !ok {
+		m.ts[txID] = ts
+	}
+
+	l, ok := m.locks[key]
+	if !ok {
+		l = &lockState{mode: mode, holders: make(map[uuid.UUID]bool)}
+		m.locks[key] = l
+	}
+	if l.holders[txID] && (mode == Shared || l.mode == Exclusive) {
+		m.mu.Unlock()
+		return nil
+	}
+	r := &request{txID: txID, mode: mode, since: m.Clock.Now()}
+	if l.grantable(r) && (len(l.queue) == 0 || l.holders[txID]) {
+		m.grant(key, l, r)
+		m.mu.Unlock()
+		return nil
+	}
+
+	m.stats.Conflicts++
+	blockers := m.blockers(l, txID)
+	die := m.Policy == NoWait
+	if m.Policy == WaitDie {
+		for _, b := range blockers {
+			if !m.older(txID, b) {
+				die = true
+			}
+		}
+	}
+	if die {
+		m.stats.Aborts++
+		if len(l.holders) == 0 && len(l.queue) == 0 {
+			delete(m.locks, key)
+		}
+		m.mu.Unlock()
+		return &ConflictError{Key: key, Mode: mode, Holders: blockers}
+	}
+
+	if l.holders[txID] {
+		// An upgrade goes ahead of everyone who does not hold the lock yet
+		l.queue = append([]*request{r}, l.queue...)
+	} else {
+		l.queue = append(l.queue, r)
+	}
+	m.waiting[txID] = key
+	m.stats.Waits++
+	detector := m.detector
+	m.mu.Unlock()
+
+	if m.Policy == Detect && detector != nil {
+		detector.Check()
+	}
+	return ErrWait
+}
+
+// grant gives r the lock; the caller must hold m.mu
+func (m *Manager) grant(key string, l *lockState, r *request) {
+	if !l.holders[r.txID] {
+		m.held[r.txID] = append(m.held[r.txID], key)
+	}
+	if len(l.holders) == 0 || r.mode == Exclusive {
+		l.mode = r.mode
+	}
+	l.holders[r.txID] = true
+	if _, ok := m.since[r.txID]; !ok {
+		m.since[r.txID] = m.Clock.Now()
+	}
+	m.stats.Acquired++
+}
+
+// blockers lists everyone txID waits for on l: the other holders, in ID
+// order, and everyone queued ahead of it
+func (m *Manager) blockers(l *lockState, txID uuid.UUID) []uuid.UUID {
+	var blockers []uuid.UUID
+	for holder := range l.holders {
+		if holder != txID {
+			blockers = append(blockers, holder)
+		}
+	}
+	sort.Slice(blockers, func(i, j int) bool { return blockers[i].String() < blockers[j].String() })
+	for _, r := range l.queue {
+		if r.txID == txID {
+			break
+		}
+		blockers = append(blockers, r.txID)
+	}
+	return blockers
+}
+
+// Wounded lists the transactions younger than txID that block its queued
+// request; under wound-wait the caller should abort those it can
+func (m *Manager) Wounded(txID uuid.UUID) []uuid.UUID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.waiting[txID]
+	if !ok {
+		return nil
+	}
+	var younger []uuid.UUID
+	for _, b := range m.blockers(m.locks[key], txID) {
+		if m.older(txID, b) {
+			younger = append(younger, b)
+		}
+	}
+	return younger
+}
+
+// Waiting reports whether txID has a queued request
+func (m *Manager) Waiting(txID uuid.UUID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.waiting[txID]
+	return ok
+}
+
+// Abort drops txID's queued request and its locks because the policy made it
+// abort, and counts the abort
+func (m *Manager) Abort(txID uuid.UUID) {
+	m.mu.Lock()
+	m.stats.Aborts++
+	m.mu.Unlock()
+	m.ReleaseAll(txID)
+}
+
+// ReleaseAll drops every lock txID holds, and any request it has queued, and
+// returns how long it held its locks. Requests that can now be granted are.
+func (m *Manager) ReleaseAll(txID uuid.UUID) time.Duration {
+	m.mu.Lock()
+
+	touched := append([]string(nil), m.held[txID]...)
+	if key, ok := m.waiting[txID]; ok {
+		l := m.locks[key]
+		for i, r := range l.queue {
+			if r.txID == txID {
+				l.queue = append(l.queue[:i], l.queue[i+1:]...)
+				break
+			}
+		}
+		delete(m.waiting, txID)
+		touched = append(touched, key)
+	}
+	for _, key := range m.held[txID] {
+		delete(m.locks[key].holders, txID)
+	}
+
+	var held time.Duration
+	if start, ok := m.since[txID]; ok {
+		held = m.Clock.Since(start)
+		m.stats.Holds++
+		m.stats.HoldTime += held
+		if held > m.stats.MaxHold {
+			m.stats.MaxHold = held
+		}
+	}
+	delete(m.held, txID)
+	delete(m.since, txID)
+	delete(m.ts, txID)
+
+	// Hand the freed locks to whoever is queued, in order
+	var granted []uuid.UUID
+	for _, key := range touched {
+		l, ok := m.locks[key]
+		if !ok {
+			continue
+		}
+		for len(l.queue) > 0 && l.grantable(l.queue[0]) {
+			r := l.queue[0]
+			l.queue = l.queue[1:]
+			m.grant(key, l, r)
+			delete(m.waiting, r.txID)
+			m.stats.WaitTime += m.Clock.Since(r.since)
+			granted = append(granted, r.txID)
+		}
+		if len(l.holders) == 0 && len(l.queue) == 0 {
+			delete(m.locks, key)
+		}
+	}
+	onGrant := m.OnGrant
+	m.mu.Unlock()
+
+	if onGrant != nil {
+		for _, g := range granted {
+			onGrant(g)
+		}
+	}
+	return held
+}
+
+// Holds reports whether txID holds a lock on key
+func (m *Manager) Holds(txID uuid.UUID, key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.locks[key]
+	return ok && l.holders[txID]
+}
+
+// waitsFor returns the manager's edges of the waits-for graph and the
+// timestamp of every transaction on them
+func (m *Manager) waitsFor() (map[uuid.UUID][]uuid.UUID, map[uuid.UUID]int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	edges := make(map[uuid.UUID][]uuid.UUID)
+	ts := make(map[uuid.UUID]int64)
+	for txID, key := range m.waiting {
+		edges[txID] = m.blockers(m.locks[key], txID)
+		ts[txID] = m.ts[txID]
+		for _, b := range edges[txID] {
+			ts[b] = m.ts[b]
+		}
+	}
+	return edges, ts
+}
+
+// Reset drops every lock and queued request without counting them, as a crash does
+func (m *Manager) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locks = make(map[string]*lockState)
+	m.held = make(map[uuid.UUID][]string)
+	m.since = make(map[uuid.UUID]time.Time)
+	m.waiting = make(map[uuid.UUID]string)
+	m.ts = make(map[uuid.UUID]int64)
+}
+
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
@@ -0,0 +1,165 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
+)
+
+func TestSharedLocksAreCompatible(t *testing.T) {
+	m := NewManager()
+	t1, t2 := uuid.New(), uuid.New()
+
+	if err := m.Acquire(t1, 0, "a", Shared); err != nil {
+		t.Fatalf("First shared lock failed: %v", err)
+	}
+	if err := m.Acquire(t2, 0, "a", Shared); err != nil {
+		t.Fatalf("Second shared lock failed: %v", err)
+	}
+
+	// Neither can upgrade while the other reads
+	var conflict *ConflictError
+	if err := m.Acquire(t1, 0, "a", Exclusive); !errors.As(err, &conflict) {
+		t.Fatalf("Expected a ConflictError on upgrade, got %v", err)
+	}
+	if len(conflict.Holders) != 1 || conflict.Holders[0] != t2 {
+		t.Errorf("Expected t2 as the conflicting holder, got %v", conflict.Holders)
+	}
+
+	m.ReleaseAll(t2)
+	if err := m.Acquire(t1, 0, "a", Exclusive); err != nil {
+		t.Errorf("Upgrade by the only holder failed: %v", err)
+	}
+}
+
+func TestExclusiveLockConflicts(t *testing.T) {
+	m := NewManager()
+	t1, t2 := uuid.New(), uuid.New()
+
+	if err := m.Acquire(t1, 0, "a", Exclusive); err != nil {
+		t.Fatalf("Exclusive lock failed: %v", err)
+	}
+	if err := m.Acquire(t1, 0, "a", Shared); err != nil {
+		t.Errorf("Re-acquiring a held lock should succeed: %v", err)
+	}
+	if err := m.Acquire(t2, 0, "a", Shared); err == nil {
+		t.Error("Shared lock should conflict with an exclusive holder")
+	}
+	if err := m.Acquire(t2, 0, "b", Exclusive); err != nil {
+		t.Errorf("Lock on another key failed: %v", err)
+	}
+
+	stats := m.Stats()
+	if stats.Acquired != 2 || stats.Conflicts != 1 {
+		t.Errorf("Expected 2 acquisitions and 1 conflict, got %+v", stats)
+	}
+}
+
+func TestReleaseAllRecordsHoldTime(t *testing.T) {
+	m := NewManager()
+	clk := clock.NewFake(time.Time{})
+	m.Clock = clk
+	txID := uuid.New()
+
+	m.Acquire(txID, 0, "a", Exclusive)
+	m.Acquire(txID, 0, "b", Shared)
+	clk.Advance(10 * time.Millisecond)
+
+	if held := m.ReleaseAll(txID); held != 10*time.Millisecond {
+		t.Errorf("Expected a hold of 10ms, got %v", held)
+	}
+	if m.Holds(txID, "a") || m.Holds(txID, "b") {
+		t.Error("ReleaseAll should drop every lock")
+	}
+	if m.ReleaseAll(txID) != 0 {
+		t.Error("Releasing twice should be a no-op")
+	}
+
+	stats := m.Stats()
+	if stats.Holds != 1 || stats.HoldTime != 10*time.Millisecond || stats.MaxHold != stats.HoldTime {
+		t.Errorf("Unexpected hold stats %+v", stats)
+	}
+}
+
+func TestQueuedRequestIsGrantedOnRelease(t *testing.T) {
+	m := NewManager()
+	m.Policy = WaitTimeout
+	var granted []uuid.UUID
+	m.OnGrant = func(txID uuid.UUID) { granted = append(granted, txID) }
+	t1, t2, t3 := uuid.New(), uuid.New(), uuid.New()
+
+	m.Acquire(t1, 1, "a", Exclusive)
+	if err := m.Acquire(t2, 2, "a", Shared); err != ErrWait {
+		t.Fatalf("Expected ErrWait, got %v", err)
+	}
+	if err := m.Acquire(t3, 3, "a", Shared); err != ErrWait {
+		t.Fatalf("Expected ErrWait, got %v", err)
+	}
+
+	// Both readers are compatible, so both get the lock
+	m.ReleaseAll(t1)
+	if len(granted) != 2 || !m.Holds(t2, "a") || !m.Holds(t3, "a") {
+		t.Errorf("Expected both queued readers to be granted, got %v", granted)
+	}
+	if m.Waiting(t2) || m.Waiting(t3) {
+		t.Error("Granted transactions should no longer be waiting")
+	}
+	if stats := m.Stats(); stats.Waits != 2 || stats.Aborts != 0 {
+		t.Errorf("Expected 2 waits and no aborts, got %+v", stats)
+	}
+}
+
+func TestWaitDieOnlyLetsOlderTransactionsWait(t *testing.T) {
+	m := NewManager()
+	m.Policy = WaitDie
+	older, holder, younger := uuid.New(), uuid.New(), uuid.New()
+
+	m.Acquire(holder, 2, "a", Exclusive)
+	if err := m.Acquire(older, 1, "a", Exclusive); err != ErrWait {
+		t.Errorf("An older transaction should wait, got %v", err)
+	}
+	var conflict *ConflictError
+	if err := m.Acquire(younger, 3, "a", Exclusive); !errors.As(err, &conflict) {
+		t.Errorf("A younger transaction should die, got %v", err)
+	}
+	if stats := m.Stats(); stats.Aborts != 1 {
+		t.Errorf("Expected 1 abort, got %+v", stats)
+	}
+}
+
+func TestWoundedListsYoungerBlockers(t *testing.T) {
+	m := NewManager()
+	m.Policy = WoundWait
+	older, holder := uuid.New(), uuid.New()
+
+	m.Acquire(holder, 2, "a", Exclusive)
+	if err := m.Acquire(older, 1, "a", Exclusive); err != ErrWait {
+		t.Fatalf("Expected ErrWait, got %v", err)
+	}
+	if wounded := m.Wounded(older); len(wounded) != 1 || wounded[0] != holder {
+		t.Errorf("Expected the younger holder to be wounded, got %v", wounded)
+	}
+	if wounded := m.Wounded(holder); len(wounded) != 0 {
+		t.Errorf("A transaction that is not waiting wounds nobody, got %v", wounded)
+	}
+
+	m.Abort(holder)
+	if !m.Holds(older, "a") {
+		t.Error("Aborting the wounded holder should grant the lock")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []Policy{NoWait, WaitTimeout, WaitDie, WoundWait, Detect} {
+		if got, err := ParsePolicy(p.String()); err != nil || got != p {
+			t.Errorf("ParsePolicy(%q) = %v, %v", p.String(), got, err)
+		}
+	}
+	if _, err := ParsePolicy("bogus"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
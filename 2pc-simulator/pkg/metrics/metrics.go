@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sample is what one transaction contributed to a run. Durations are
+// written out in nanoseconds.
+type Sample struct {
+	ID           string        `json:"id"`
+	Participants int           `json:"participants"`
+	OnePhase     bool          `json:"one_phase"`
+	Latency      time.Duration `json:"latency_ns"`
+	Committed    bool          `json:"committed"`
+	// Phases holds the time spent in each named phase of the protocol
+	Phases  map[string]time.Duration `json:"phases_ns"`
+	Retries int                      `json:"retries"`
+	Drops   int                      `json:"drops"`
+	// Blocking holds, for each participant that voted yes, how long it
+	// waited in Ready for the decision
+	Blocking []time.Duration `json:"blocking_ns"`
+	// Unresolved holds, for each participant still in Ready when the run
+	// ended, how long it had waited by then. These windows never closed, so
+	// they are kept out of Blocking.
+	Unresolved []time.Duration `json:"unresolved_ns"`
+}
+
+// Summary describes a set of durations
+type Summary struct {
+	Count int           `json:"count"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// Summarize computes percentiles by the nearest-rank method
+func Summarize(ds []time.Duration) Summary {
+	if len(ds) == 0 {
+		return Summary{}
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	rank := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	return Summary{
+		Count: len(sorted),
+		Mean:  total / time.Duration(len(sorted)),
+		P50:   rank(0.50),
+		P90:   rank(0.90),
+		P99:   rank(0.99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("p50 %v, p90 %v, p99 %v, max %v (mean %v)",
+		s.P50.Round(time.Microsecond), s.P90.Round(time.Microsecond), s.P99.Round(time.Microsecond),
+		s.Max.Round(time.Microsecond), s.Mean.Round(time.Microsecond))
+}
+
+// Recorder collects samples; it is safe for concurrent use
+type Recorder struct {
+	mu      sync.Mutex
+	samples []Sample
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Record adds one transaction's sample
+func (r *Recorder) Record(s Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, s)
+}
+
+// Samples returns everything recorded so far, in order
+func (r *Recorder) Samples() []Sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Sample(nil), r.samples...)
+}
+
+// Report aggregates a run
+type Report struct {
+	Transactions int `json:"transactions"`
+	Committed    int `json:"committed"`
+	Aborted      int `json:"aborted"`
+	// AbortRate is the fraction of transactions that did not commit
+	AbortRate float64 `json:"abort_rate"`
+	// Throughput is committed transactions per second of the run
+	Throughput float64 `json:"throughput"`
+	// Latency covers every transaction; Phases only those that went through each phase
+	Latency Summary            `json:"latency"`
+	Phases  map[string]Summary `json:"phases"`
+	// Blocking covers every participant's time in Ready, across all transactions
+	Blocking Summary `json:"blocking"`
+	// Unresolved covers the participants still blocked in Ready at the end
+	Unresolved Summary `json:"unresolved"`
+	Retries    int     `json:"retries"`
+	Drops      int     `json:"drops"`
+}
+
+// Report aggregates everything recorded over a run that lasted elapsed
+func (r *Recorder) Report(elapsed time.Duration) Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep := Report{Transactions: len(r.samples), Phases: make(map[string]Summary)}
+	var latencies []time.Duration
+	phases := make(map[string][]time.Duration)
+	var blocking, unresolved []time.Duration
+	for _, s := range r.samples {
+		if s.Committed {
+			rep.Committed++
+		}
+		latencies = append(latencies, s.Latency)
+		for name, d := range s.Phases {
+			if d > 0 {
+				phases[name] = append(phases[name], d)
+			}
+		}
+		blocking = append(blocking, s.Blocking...)
+		unresolved = append(unresolved, s.Unresolved...)
+		rep.Retries += s.Retries
+		rep.Drops += s.Drops
+	}
+	rep.Aborted = rep.Transactions - rep.Committed
+	if rep.Transactions > 0 {
+		rep.AbortRate = float64(rep.Aborted) / float64(rep.Transactions)
+	}
+	if elapsed > 0 {
+		rep.Throughput = float64(rep.Committed) / elapsed.Seconds()
+	}
+	rep.Latency = Summarize(latencies)
+	rep.Blocking = Summarize(blocking)
+	rep.Unresolved = Summarize(unresolved)
+	for name, ds := range phases {
+		rep.Phases[name] = Summarize(ds)
+	}
+	return rep
+}
+
+// Print writes the report in the CLI's results format
+func (rep Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Throughput: %.1f committed tx/s\n", rep.Throughput)
+	fmt.Fprintf(w, "Abort Rate: %.1f%% (%d of %d)\n", rep.AbortRate*100, rep.Aborted, rep.Transactions)
+	fmt.Fprintf(w, "Latency: %s\n", rep.Latency)
+	var names []string
+	for name := range rep.Phases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-18s %s\n", name, rep.Phases[name])
+	}
+	if rep.Blocking.Count > 0 {
+		fmt.Fprintf(w, "Blocking in Ready: %s over %d yes vote(s)\n", rep.Blocking, rep.Blocking.Count)
+	} else {
+		fmt.Fprintf(w, "Blocking in Ready: none\n")
+	}
+	if rep.Unresolved.Count > 0 {
+		fmt.Fprintf(w, "Still in Ready at the end: %s over %d yes vote(s)\n", rep.Unresolved, rep.Unresolved.Count)
+	}
+	fmt.Fprintf(w, "Retries: %d\n", rep.Retries)
+	fmt.Fprintf(w, "Drops: %d\n", rep.Drops)
+}
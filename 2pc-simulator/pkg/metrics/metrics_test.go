@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarizePercentiles(t *testing.T) {
+	var ds []time.Duration
+	for i := 100; i >= 1; i-- {
+		ds = append(ds, time.Duration(i)*time.Millisecond)
+	}
+	s := Summarize(ds)
+	if s.P50 != 50*time.Millisecond || s.P90 != 90*time.Millisecond || s.P99 != 99*time.Millisecond || s.Max != 100*time.Millisecond {
+		t.Errorf("Unexpected percentiles %+v", s)
+	}
+	if s.Mean != 50500*time.Microsecond || s.Count != 100 {
+		t.Errorf("Unexpected mean or count %+v", s)
+	}
+	if ds[0] != 100*time.Millisecond {
+		t.Error("Summarize should not reorder its input")
+	}
+	if (Summarize(nil) != Summary{}) {
+		t.Error("An empty set should summarize to zero")
+	}
+}
+
+func TestRecorderReport(t *testing.T) {
+	r := NewRecorder()
+	r.Record(Sample{Latency: 10 * time.Millisecond, Committed: true, Phases: map[string]time.Duration{"voting": 6 * time.Millisecond, "acking": 4 * time.Millisecond}, Blocking: []time.Duration{3 * time.Millisecond, 5 * time.Millisecond}})
+	r.Record(Sample{Latency: 30 * time.Millisecond, Committed: true, Phases: map[string]time.Duration{"voting": 30 * time.Millisecond}, Retries: 2, Drops: 1, Blocking: []time.Duration{25 * time.Millisecond}})
+	r.Record(Sample{Latency: 20 * time.Millisecond, Phases: map[string]time.Duration{"voting": 20 * time.Millisecond}})
+	r.Record(Sample{Latency: 40 * time.Millisecond, Retries: 1, Unresolved: []time.Duration{900 * time.Millisecond}})
+
+	rep := r.Report(time.Second)
+	if rep.Transactions != 4 || rep.Committed != 2 || rep.Aborted != 2 || rep.AbortRate != 0.5 {
+		t.Errorf("Unexpected outcome counts %+v", rep)
+	}
+	if rep.Throughput != 2 {
+		t.Errorf("Expected 2 committed tx/s, got %v", rep.Throughput)
+	}
+	if rep.Latency.P50 != 20*time.Millisecond || rep.Latency.Max != 40*time.Millisecond {
+		t.Errorf("Unexpected latency summary %+v", rep.Latency)
+	}
+	if rep.Phases["voting"].Count != 3 || rep.Phases["acking"].Count != 1 {
+		t.Errorf("Phases should only count transactions that went through them, got %+v", rep.Phases)
+	}
+	if rep.Blocking.Count != 3 || rep.Blocking.P50 != 5*time.Millisecond || rep.Blocking.Max != 25*time.Millisecond {
+		t.Errorf("Blocking should cover every participant of every transaction, got %+v", rep.Blocking)
+	}
+	// A window still open at the end is counted apart from the closed ones
+	if rep.Unresolved.Count != 1 || rep.Unresolved.Max != 900*time.Millisecond {
+		t.Errorf("Expected one unresolved window of 900ms, got %+v", rep.Unresolved)
+	}
+	if rep.Retries != 3 || rep.Drops != 1 {
+		t.Errorf("Expected 3 retries and 1 drop, got %d and %d", rep.Retries, rep.Drops)
+	}
+
+	var out bytes.Buffer
+	rep.Print(&out)
+	for _, want := range []string{"Throughput: 2.0", "Abort Rate: 50.0%", "p99 40ms", "acking", "Blocking in Ready: p50 5ms", "Still in Ready at the end: p50 900ms", "Retries: 3"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Report output is missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SchemaVersion identifies the layout of written results. It changes
+// whenever a field is renamed, removed or changes meaning; new fields may be
+// added without changing it.
+const SchemaVersion = 1
+
+// Results is everything a run writes out for external tools
+type Results struct {
+	Schema int `json:"schema"`
+	// Config holds the value of every setting the run used, by flag name
+	Config       map[string]string `json:"config"`
+	Transactions []Sample          `json:"transactions"`
+	Summary      Report            `json:"summary"`
+}
+
+// NewResults bundles a run's settings, samples and report
+func NewResults(config map[string]string, samples []Sample, report Report) Results {
+	return Results{Schema: SchemaVersion, Config: config, Transactions: samples, Summary: report}
+}
+
+// Write writes res in format, json or csv
+func (res Results) Write(w io.Writer, format string) error {
+	switch format {
+	case "json":
+		return res.WriteJSON(w)
+	case "csv":
+		return res.WriteCSV(w)
+	default:
+		return fmt.Errorf("unknown output format %q (want json or csv)", format)
+	}
+}
+
+// WriteJSON writes res as one indented JSON document. Map keys come out
+// sorted, so two runs with the same settings diff cleanly.
+func (res Results) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+// WriteCSV writes one row per transaction under a header row. The schema
+// version, the settings and the summary come first, as "#" comment lines of
+// the form "# section key value".
+func (res Results) WriteCSV(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# schema %d\n", res.Schema); err != nil {
+		return err
+	}
+	for _, name := range sortedKeys(res.Config) {
+		if _, err := fmt.Fprintf(w, "# config %s %s\n", name, res.Config[name]); err != nil {
+			return err
+		}
+	}
+	for _, kv := range res.Summary.fields() {
+		if _, err := fmt.Fprintf(w, "# summary %s %s\n", kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+
+	// Every phase any transaction went through gets a column
+	seen := make(map[string]bool)
+	for _, s := range res.Transactions {
+		for name := range s.Phases {
+			seen[name] = true
+		}
+	}
+	phases := sortedKeys(seen)
+
+	cw := csv.NewWriter(w)
+	header := []string{"id", "participants", "one_phase", "committed", "latency_ns"}
+	for _, name := range phases {
+		header = append(header, name+"_ns")
+	}
+	header = append(header, "retries", "drops", "blocked_participants", "max_blocking_ns",
+		"unresolved_participants", "max_unresolved_ns")
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, s := range res.Transactions {
+		var maxBlocking, maxUnresolved time.Duration
+		for _, d := range s.Blocking {
+			maxBlocking = max(maxBlocking, d)
+		}
+		for _, d := range s.Unresolved {
+			maxUnresolved = max(maxUnresolved, d)
+		}
+		row := []string{s.ID, strconv.Itoa(s.Participants), strconv.FormatBool(s.OnePhase),
+			strconv.FormatBool(s.Committed), nanos(s.Latency)}
+		for _, name := range phases {
+			row = append(row, nanos(s.Phases[name]))
+		}
+		row = append(row, strconv.Itoa(s.Retries), strconv.Itoa(s.Drops),
+			strconv.Itoa(len(s.Blocking)), nanos(maxBlocking),
+			strconv.Itoa(len(s.Unresolved)), nanos(maxUnresolved))
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// fields flattens the report into ordered key/value pairs
+func (rep Report) fields() [][2]string {
+	kvs := [][2]string{
+		{"transactions", strconv.Itoa(rep.Transactions)},
+		{"committed", strconv.Itoa(rep.Committed)},
+		{"aborted", strconv.Itoa(rep.Aborted)},
+		{"abort_rate", strconv.FormatFloat(rep.AbortRate, 'f', -1, 64)},
+		{"throughput", strconv.FormatFloat(rep.Throughput, 'f', -1, 64)},
+	}
+	kvs = append(kvs, rep.Latency.fields("latency")...)
+	for _, name := range sortedKeys(rep.Phases) {
+		kvs = append(kvs, rep.Phases[name].fields("phase_"+name)...)
+	}
+	kvs = append(kvs, rep.Blocking.fields("blocking")...)
+	kvs = append(kvs, rep.Unresolved.fields("unresolved")...)
+	return append(kvs,
+		[2]string{"retries", strconv.Itoa(rep.Retries)},
+		[2]string{"drops", strconv.Itoa(rep.Drops)})
+}
+
+func (s Summary) fields(prefix string) [][2]string {
+	return [][2]string{
+		{prefix + "_count", strconv.Itoa(s.Count)},
+		{prefix + "_mean_ns", nanos(s.Mean)},
+		{prefix + "_p50_ns", nanos(s.P50)},
+		{prefix + "_p90_ns", nanos(s.P90)},
+		{prefix + "_p99_ns", nanos(s.P99)},
+		{prefix + "_max_ns", nanos(s.Max)},
+	}
+}
+
+func nanos(d time.Duration) string {
+	return strconv.FormatInt(int64(d), 10)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
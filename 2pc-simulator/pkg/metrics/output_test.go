@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testResults() Results {
+	r := NewRecorder()
+	r.Record(Sample{ID: "tx-1", Participants: 2, Latency: 10 * time.Millisecond, Committed: true,
+		Phases:   map[string]time.Duration{"voting": 6 * time.Millisecond, "acking": 4 * time.Millisecond},
+		Blocking: []time.Duration{3 * time.Millisecond, 5 * time.Millisecond}})
+	r.Record(Sample{ID: "tx-2", Participants: 1, OnePhase: true, Latency: 2 * time.Millisecond, Retries: 1,
+		Unresolved: []time.Duration{7 * time.Millisecond}})
+	return NewResults(map[string]string{"participants": "3", "latency": "10"}, r.Samples(), r.Report(time.Second))
+}
+
+func TestResultsJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := testResults().Write(&out, "json"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	var got Results
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Output is not valid JSON: %v", err)
+	}
+	if got.Schema != SchemaVersion || got.Config["participants"] != "3" {
+		t.Errorf("Unexpected schema or config %d %v", got.Schema, got.Config)
+	}
+	if len(got.Transactions) != 2 || got.Transactions[0].Phases["voting"] != 6*time.Millisecond || !got.Transactions[1].OnePhase {
+		t.Errorf("Transactions did not round-trip: %+v", got.Transactions)
+	}
+	if got.Summary.Committed != 1 || got.Summary.Blocking.Max != 5*time.Millisecond {
+		t.Errorf("Summary did not round-trip: %+v", got.Summary)
+	}
+	for _, want := range []string{`"latency_ns": 10000000`, `"p99_ns"`, `"abort_rate": 0.5`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("JSON is missing %s:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestResultsCSV(t *testing.T) {
+	var out bytes.Buffer
+	if err := testResults().Write(&out, "csv"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	var comments []string
+	var body strings.Builder
+	for _, line := range strings.SplitAfter(out.String(), "\n") {
+		if strings.HasPrefix(line, "#") {
+			comments = append(comments, strings.TrimSpace(line))
+		} else {
+			body.WriteString(line)
+		}
+	}
+	if len(comments) < 3 || comments[0] != "# schema 1" || comments[1] != "# config latency 10" {
+		t.Errorf("Settings should come first, sorted, got %v", comments)
+	}
+	for _, want := range []string{"# summary blocking_max_ns 5000000\n", "# summary unresolved_count 1\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Summary is missing %q:\n%s", want, out.String())
+		}
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(body.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("Output is not valid CSV: %v", err)
+	}
+	want := "id,participants,one_phase,committed,latency_ns,acking_ns,voting_ns,retries,drops,blocked_participants,max_blocking_ns,unresolved_participants,max_unresolved_ns"
+	if len(rows) != 3 || strings.Join(rows[0], ",") != want {
+		t.Fatalf("Unexpected header or row count: %v", rows)
+	}
+	if got := strings.Join(rows[1], ","); got != "tx-1,2,false,true,10000000,4000000,6000000,0,0,2,5000000,0,0" {
+		t.Errorf("Unexpected row %s", got)
+	}
+	if got := strings.Join(rows[2], ","); got != "tx-2,1,true,false,2000000,0,0,1,0,0,0,1,7000000" {
+		t.Errorf("Unexpected row %s", got)
+	}
+}
+
+func TestResultsUnknownFormat(t *testing.T) {
+	if err := testResults().Write(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -2,10 +2,23 @@ package node
 
 import (
 	"sync"
+	"time"
 
+	"2pc-sim/pkg/clock"
 	"2pc-sim/pkg/protocol"
 )
 
+// newTestParticipant creates a participant of coordinator "coord" on a fake
+// clock, so its timers only fire when the test advances the clock and its
+// handlers can be called directly
+func newTestParticipant(id string, net *MockNetwork) (*Participant, *clock.Fake) {
+	clk := clock.NewFake(time.Time{})
+	p := NewParticipant(id, net, "coord")
+	p.Clock = clk
+	p.Locks.Clock = clk
+	return p, clk
+}
+
 // MockNetwork captures sent messages for verification
 type MockNetwork struct {
 	mu           sync.Mutex
@@ -1,14 +1,20 @@
 package node
 
 import (
-	"context"
+	"fmt"
+	"hash/fnv"
 	"log"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
+	"2pc-sim/pkg/clock"
 	"2pc-sim/pkg/protocol"
 	"2pc-sim/pkg/transport"
+	"2pc-sim/pkg/wal"
 )
 
 type Coordinator struct {
@@ -18,6 +24,60 @@ type Coordinator struct {
 	Inbox         chan protocol.Message
 	Timeout       time.Duration
 	RetryInterval time.Duration
+	Log           wal.Log
+	Mode          CommitMode
+	// Owner maps a key to the participant that stores it, typically a
+	// partition.Partitioner's Owner; by default keys are spread over
+	// Participants by hash
+	Owner func(key string) string
+	// OnePhase commits transactions with a single participant in one message
+	// instead of running the 2PC rounds
+	OnePhase bool
+	// CrashPoint schedules a single crash in the first transaction to reach
+	// it. The coordinator restarts after CrashDowntime, or when Recover is
+	// called if that is 0.
+	CrashPoint    CrashPoint
+	CrashDowntime time.Duration
+	// Retention is how long a decision stays in the decision table once no
+	// participant can need it: everyone acknowledged it, or it is the one
+	// the mode presumes
+	Retention time.Duration
+	// Clock times transactions and drives the retry and timeout timers
+	Clock     clock.Clock
+	mu        sync.Mutex
+	decisions map[uuid.UUID]protocol.MessageType
+	txns      map[uuid.UUID]*coordTxn // transactions in progress
+	// orphans are the callers' handles on transactions whose outcome a crash
+	// left open: logged commits, and one-phase ones whose participant decides.
+	// Restart finishes them.
+	orphans map[uuid.UUID]*Txn
+	stop    chan struct{}
+	crashed bool
+}
+
+// coordPhase is the step a transaction is waiting on
+type coordPhase int
+
+const (
+	phaseVoting coordPhase = iota
+	phaseAcking
+	phaseOnePhase // waiting for the only participant's outcome
+)
+
+// coordTxn is the coordinator's state machine for one transaction.
+// Its fields are guarded by the coordinator's mutex.
+type coordTxn struct {
+	*Txn
+	phase    coordPhase
+	ops      map[string][]protocol.Operation // each participant's share of the work
+	pending  map[string]bool                 // participants that still owe a vote or an Ack
+	readOnly map[string]bool
+	aborted  bool
+	decision protocol.MessageType
+	decided  time.Time // when Phase 2 started
+	ended    bool      // every participant acknowledged the decision
+	retry    clock.Timer
+	timeout  clock.Timer
 }
 
 func NewCoordinator(id string, net transport.Network, participants []string, timeout time.Duration, retryInterval time.Duration) *Coordinator {
@@ -28,132 +88,675 @@ func NewCoordinator(id string, net transport.Network, participants []string, tim
 		Inbox:         make(chan protocol.Message, 100),
 		Timeout:       timeout,
 		RetryInterval: retryInterval,
+		Log:           wal.NewMemoryLog(0),
+		Retention:     DefaultRetention,
+		Clock:         clock.Real{},
+		decisions:     make(map[uuid.UUID]protocol.MessageType),
+		txns:          make(map[uuid.UUID]*coordTxn),
+		orphans:       make(map[uuid.UUID]*Txn),
+		stop:          make(chan struct{}),
 	}
 }
 
 func (c *Coordinator) Start() {
+	c.mu.Lock()
+	stop := c.stop
+	c.mu.Unlock()
 	c.Net.Register(c.ID, c.Inbox)
+	serve(c.Clock, stop, c.Inbox, func(msg protocol.Message) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.stop == stop && !c.crashed {
+			c.handleMessage(msg)
+		}
+	})
 }
 
-// RunTransaction executes a 2PC transaction
-// Returns true if committed, false if aborted
-func (c *Coordinator) RunTransaction() (bool, time.Duration) {
-	txID := uuid.New()
-	startTime := time.Now()
+// handleMessage answers decision inquiries itself and hands everything else
+// to the transaction it belongs to. The caller must hold c.mu.
+func (c *Coordinator) handleMessage(msg protocol.Message) {
+	if msg.Type == protocol.MsgDecisionRequest {
+		c.answerInquiry(msg)
+		return
+	}
+	tx, ok := c.txns[msg.TransactionID]
+	if !ok {
+		return
+	}
 
-	log.Printf("[Coordinator] Starting Tx %s", txID)
+	switch tx.phase {
+	case phaseOnePhase:
+		if msg.Type != protocol.MsgOnePhaseReply {
+			return
+		}
+		c.collectReads(tx, msg)
+		tx.Voting = c.Clock.Since(tx.start)
+		log.Printf("[Coordinator] %s decided %s for Tx %s in one phase", msg.FromID, msg.Decision, tx.ID)
+		c.complete(tx, msg.Decision == protocol.MsgCommit)
+	case phaseVoting:
+		switch msg.Type {
+		case protocol.MsgVoteNo:
+			log.Printf("[Coordinator] Received VoteNo from %s", msg.FromID)
+			tx.aborted = true
+			c.decide(tx)
+			return
+		case protocol.MsgWound:
+			// An older transaction waits for one of our locks at a
+			// participant where we have voted Yes
+			log.Printf("[Coordinator] Tx %s wounded at %s, aborting it", tx.ID, msg.FromID)
+			tx.aborted = true
+			c.decide(tx)
+			return
+		case protocol.MsgVoteYes:
+			c.collectReads(tx, msg)
+			delete(tx.pending, msg.FromID)
+		case protocol.MsgVoteReadOnly:
+			if tx.pending[msg.FromID] {
+				c.collectReads(tx, msg)
+				tx.readOnly[msg.FromID] = true
+				delete(tx.pending, msg.FromID)
+			}
+		}
+		if len(tx.pending) == 0 {
+			c.decide(tx)
+		}
+	case phaseAcking:
+		if msg.Type != protocol.MsgAck {
+			return
+		}
+		delete(tx.pending, msg.FromID)
+		if len(tx.pending) == 0 {
+			// Everyone has the decision, the transaction can be forgotten
+			if err := c.Log.Append(wal.Record{Type: wal.RecEnd, TransactionID: tx.ID}, false); err != nil {
+				log.Printf("[Coordinator] Failed to log end of Tx %s: %v", tx.ID, err)
+			} else {
+				tx.ended = true
+			}
+			c.complete(tx, !tx.aborted)
+		}
+	}
+}
 
-	// Helper closure to send message to a specific participant
-	sendTo := func(to string, msgType protocol.MessageType) {
-		c.Net.Send(protocol.Message{
-			Type:          msgType,
-			TransactionID: txID,
-			FromID:        c.ID,
-			ToID:          to,
-		})
+// collectReads keeps the results of the Gets a participant ran for us
+func (c *Coordinator) collectReads(tx *coordTxn, vote protocol.Message) {
+	if !tx.pending[vote.FromID] {
+		return
+	}
+	for _, op := range vote.Operations {
+		if op.Type == protocol.OpGet && op.Found {
+			tx.reads[op.Key] = op.Value
+		}
+	}
+}
+
+// answerInquiry replies to a participant asking for the outcome of a transaction.
+// A transaction still collecting votes gets no answer; for one we know nothing
+// about the mode's presumption applies.
+func (c *Coordinator) answerInquiry(msg protocol.Message) {
+	decision, decided := c.decisions[msg.TransactionID]
+	if !decided {
+		if _, active := c.txns[msg.TransactionID]; active {
+			return
+		}
+		decision = c.Mode.presumed()
 	}
+	log.Printf("[Coordinator] Answering inquiry from %s for Tx %s: %s", msg.FromID, msg.TransactionID, decision)
+	c.Net.Send(protocol.Message{
+		Type:          protocol.MsgDecisionReply,
+		TransactionID: msg.TransactionID,
+		FromID:        c.ID,
+		ToID:          msg.FromID,
+		Decision:      decision,
+	})
+}
 
-	// Phase 1: Prepare
-	c.broadcast(protocol.MsgPrepare, txID)
-
-	// Wait for votes
-	votes := make(map[string]bool)
-	pendingVotes := make(map[string]bool)
-	for _, p := range c.Participants {
-		pendingVotes[p] = true
-	}
-
-	aborted := false
-
-	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
-	defer cancel()
-
-	// Retry loop for Phase 1
-	ticker := time.NewTicker(c.RetryInterval)
-	defer ticker.Stop()
-
-Loop:
-	for len(pendingVotes) > 0 {
-		select {
-		case <-ctx.Done():
-			log.Printf("[Coordinator] Timeout waiting for votes in Tx %s", txID)
-			aborted = true
-			break Loop
-		case <-ticker.C:
-			// Retry Prepare for those who haven't voted
-			for pID := range pendingVotes {
-				// We don't log every retry to avoid spam
-				sendTo(pID, protocol.MsgPrepare)
+// Decision returns the logged outcome of a transaction, if one was reached
+func (c *Coordinator) Decision(txID uuid.UUID) (protocol.MessageType, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.decisions[txID]
+	return d, ok
+}
+
+// Crash stops the coordinator and throws away everything that is not in its log.
+// Transactions in progress that were never decided finish immediately as not
+// committed, which presumed abort makes true. Those with a logged Commit, and
+// one-phase ones, stay open until Restart learns how they ended.
+func (c *Coordinator) Crash() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.crash()
+}
+
+func (c *Coordinator) crash() {
+	if c.crashed {
+		return
+	}
+	log.Printf("[Coordinator] CRASHED")
+	c.crashed = true
+	c.Net.Unregister(c.ID)
+	close(c.stop)
+	for _, tx := range c.txns {
+		c.stopTimers(tx)
+		if c.decisions[tx.ID] == protocol.MsgCommit || tx.phase == phaseOnePhase {
+			c.orphans[tx.ID] = tx.Txn
+			continue
+		}
+		tx.resolve(false)
+	}
+	c.decisions = make(map[uuid.UUID]protocol.MessageType)
+	c.txns = make(map[uuid.UUID]*coordTxn)
+}
+
+// Recover restarts a crashed coordinator from its log. Transactions that began
+// but were never decided are aborted, and Phase 2 is resumed for every decided
+// transaction that was not acknowledged by all of its participants. One-phase
+// transactions the crash interrupted are asked to abort, and end as their
+// participant answers. It blocks until they finish and returns the outcome
+// of each resumed transaction.
+func (c *Coordinator) Recover() (map[uuid.UUID]bool, error) {
+	resumed, err := c.Restart()
+	if err != nil {
+		return nil, err
+	}
+	outcomes := make(map[uuid.UUID]bool)
+	for txID, handle := range resumed {
+		outcomes[txID], _ = handle.Wait()
+	}
+	return outcomes, nil
+}
+
+// Crashed reports whether the coordinator is down
+func (c *Coordinator) Crashed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.crashed
+}
+
+// Restart recovers like Recover but returns the resumed transactions without
+// waiting for them, which a discrete-event simulation must not do. It fails
+// if the coordinator is not down.
+func (c *Coordinator) Restart() (map[uuid.UUID]*Txn, error) {
+	if !c.Crashed() {
+		return nil, fmt.Errorf("coordinator %s is not crashed", c.ID)
+	}
+	records, err := c.Log.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	type logged struct {
+		participants []string
+		decision     protocol.MessageType
+		decided      bool
+		ended        bool
+	}
+	var order []uuid.UUID
+	txs := make(map[uuid.UUID]*logged)
+	for _, rec := range records {
+		tx, ok := txs[rec.TransactionID]
+		if !ok {
+			tx = &logged{}
+			txs[rec.TransactionID] = tx
+			order = append(order, rec.TransactionID)
+		}
+		if rec.Participants != nil {
+			tx.participants = rec.Participants
+		}
+		switch rec.Type {
+		case wal.RecCommit:
+			tx.decision, tx.decided = protocol.MsgCommit, true
+		case wal.RecAbort:
+			tx.decision, tx.decided = protocol.MsgAbort, true
+		case wal.RecEnd:
+			tx.ended = true
+		}
+	}
+
+	// Messages queued while we were down were lost with the process
+	for len(c.Inbox) > 0 {
+		<-c.Inbox
+	}
+
+	c.mu.Lock()
+	for _, txID := range order {
+		// Only decisions a participant may still ask about
+		if tx := txs[txID]; tx.decided && !tx.ended && tx.decision != c.Mode.presumed() {
+			c.decisions[txID] = tx.decision
+		}
+	}
+	c.crashed = false
+	// crash closed the old loop's stop channel; make sure of it, so that only
+	// the new loop reads the inbox
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	c.stop = make(chan struct{})
+	c.mu.Unlock()
+	c.Start()
+	log.Printf("[Coordinator] RECOVERED with %d logged transactions", len(order))
+
+	c.mu.Lock()
+	resumed := make(map[uuid.UUID]*Txn)
+	for _, txID := range order {
+		tx := txs[txID]
+		handle := c.orphans[txID]
+		delete(c.orphans, txID)
+		if tx.ended || (tx.decided && !c.Mode.acked(tx.decision)) {
+			// Nobody owes us an Ack for this one
+			if handle != nil {
+				handle.resolve(tx.decision == protocol.MsgCommit)
 			}
-		case msg := <-c.Inbox:
-			if msg.TransactionID != txID {
+			continue
+		}
+		if !tx.decided {
+			// Nobody can have been told to commit, so aborting is safe
+			tx.decision = protocol.MsgAbort
+			if !c.logDecision(txID, tx.decision, tx.participants) {
 				continue
 			}
-			if msg.Type == protocol.MsgVoteNo {
-				log.Printf("[Coordinator] Received VoteNo from %s", msg.FromID)
-				aborted = true
-				break Loop
-			} else if msg.Type == protocol.MsgVoteYes {
-				if !votes[msg.FromID] {
-					votes[msg.FromID] = true
-					delete(pendingVotes, msg.FromID)
-				}
+		}
+		log.Printf("[Coordinator] Resuming Phase 2 for Tx %s: %s", txID, tx.decision)
+		if handle == nil {
+			handle = newTxn(c.Clock)
+			handle.ID = txID
+			handle.Participants = tx.participants
+		}
+		ct := &coordTxn{
+			Txn:     handle,
+			aborted: tx.decision == protocol.MsgAbort,
+		}
+		c.txns[txID] = ct
+		resumed[txID] = ct.Txn
+		c.startPhase2(ct, tx.decision, tx.participants)
+	}
+
+	// What is left ran in one phase and is in no log of ours, so ask the
+	// participant to abort and let its answer say how it ended. Oldest
+	// first, so that a seeded simulation replays the same sends.
+	var onePhase []*Txn
+	for _, handle := range c.orphans {
+		onePhase = append(onePhase, handle)
+	}
+	slices.SortFunc(onePhase, func(a, b *Txn) int {
+		if d := a.start.Compare(b.start); d != 0 {
+			return d
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	for _, handle := range onePhase {
+		delete(c.orphans, handle.ID)
+		pID := handle.Participants[0]
+		log.Printf("[Coordinator] Asking %s for the one-phase outcome of Tx %s", pID, handle.ID)
+		ct := &coordTxn{
+			Txn:     handle,
+			phase:   phaseOnePhase,
+			pending: map[string]bool{pID: true},
+			aborted: true,
+		}
+		c.txns[handle.ID] = ct
+		resumed[handle.ID] = handle
+		c.sendPrepare(pID, ct)
+		ct.retry = c.Clock.AfterFunc(c.RetryInterval, func() { c.onRetry(ct) })
+	}
+	c.mu.Unlock()
+	return resumed, nil
+}
+
+// crashAt fires the scheduled crash if it is set for point
+func (c *Coordinator) crashAt(point CrashPoint) bool {
+	if c.CrashPoint != point {
+		return false
+	}
+	c.CrashPoint = CrashNone
+	c.crash()
+	if c.CrashDowntime > 0 {
+		c.Clock.AfterFunc(c.CrashDowntime, func() {
+			if _, err := c.Restart(); err != nil {
+				log.Printf("[Coordinator] Failed to restart: %v", err)
 			}
+		})
+	}
+	return true
+}
+
+// RunTransaction executes a 2PC transaction and waits for its outcome
+// Returns true if committed, false if aborted
+func (c *Coordinator) RunTransaction() (bool, time.Duration) {
+	return c.Begin().Wait()
+}
+
+// Begin starts a 2PC transaction over all participants without waiting for
+// it; any number of transactions can be in flight at once
+func (c *Coordinator) Begin() *Txn {
+	return c.BeginOps(nil)
+}
+
+// BeginOps starts a 2PC transaction that runs ops. Each operation goes to the
+// participant owning its key, and only those participants take part.
+func (c *Coordinator) BeginOps(ops []protocol.Operation) *Txn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx := &coordTxn{
+		Txn:      newTxn(c.Clock),
+		phase:    phaseVoting,
+		pending:  make(map[string]bool),
+		readOnly: make(map[string]bool),
+	}
+	tx.Participants = c.Participants
+	var err error
+	if len(c.Participants) == 0 {
+		err = fmt.Errorf("no participants")
+	} else if len(ops) > 0 {
+		tx.Participants, tx.ops, err = c.route(ops)
+	}
+	if err != nil {
+		log.Printf("[Coordinator] Cannot start Tx %s: %v", tx.ID, err)
+		tx.resolve(false)
+		return tx.Txn
+	}
+	if c.crashed {
+		log.Printf("[Coordinator] Cannot start Tx %s while down", tx.ID)
+		tx.resolve(false)
+		return tx.Txn
+	}
+
+	log.Printf("[Coordinator] Starting Tx %s", tx.ID)
+	c.txns[tx.ID] = tx
+
+	if c.OnePhase && len(tx.Participants) == 1 {
+		c.beginOnePhase(tx)
+		return tx.Txn
+	}
+
+	// Record who takes part, so a recovering coordinator can abort the
+	// transaction. Presumed abort needs no record here; presumed commit must
+	// force it, or a crash would let the transaction be presumed committed.
+	if c.Mode != ModePresumedAbort {
+		rec := wal.Record{Type: wal.RecBegin, TransactionID: tx.ID, Participants: tx.Participants}
+		if err := c.Log.Append(rec, c.Mode == ModePresumedCommit); err != nil {
+			log.Printf("[Coordinator] Failed to log start of Tx %s: %v", tx.ID, err)
+		}
+	}
+
+	// Phase 1: Prepare
+	for _, pID := range tx.Participants {
+		tx.pending[pID] = true
+		c.sendPrepare(pID, tx)
+	}
+	if c.crashAt(CrashPrepare) {
+		return tx.Txn
+	}
+	if len(tx.pending) == 0 {
+		c.decide(tx)
+		return tx.Txn
+	}
+	c.arm(tx)
+	return tx.Txn
+}
+
+// beginOnePhase hands a transaction over to its only participant, which runs
+// it, decides and replies. That saves the voting round and every log write at
+// the coordinator: the participant's log alone holds the outcome.
+func (c *Coordinator) beginOnePhase(tx *coordTxn) {
+	tx.phase = phaseOnePhase
+	tx.OnePhase = true
+	pID := tx.Participants[0]
+	tx.pending[pID] = true
+	c.sendPrepare(pID, tx)
+	if c.crashAt(CrashPrepare) {
+		return
+	}
+	c.arm(tx)
+}
+
+// arm restarts the retry and timeout timers for the transaction's current phase
+func (c *Coordinator) arm(tx *coordTxn) {
+	c.stopTimers(tx)
+	phase := tx.phase
+	tx.retry = c.Clock.AfterFunc(c.RetryInterval, func() { c.onRetry(tx) })
+	tx.timeout = c.Clock.AfterFunc(c.Timeout, func() { c.onTimeout(tx, phase) })
+}
+
+func (c *Coordinator) stopTimers(tx *coordTxn) {
+	if tx.retry != nil {
+		tx.retry.Stop()
+		tx.retry = nil
+	}
+	if tx.timeout != nil {
+		tx.timeout.Stop()
+		tx.timeout = nil
+	}
+}
+
+// onRetry resends Prepare or the decision to everyone who has not answered
+func (c *Coordinator) onRetry(tx *coordTxn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.txns[tx.ID] != tx || tx.retry == nil {
+		return
+	}
+	// In participant order, so that a seeded simulation replays the same sends
+	for _, pID := range tx.Participants {
+		if !tx.pending[pID] {
+			continue
+		}
+		// We don't log every retry to avoid spam
+		if tx.phase != phaseAcking {
+			c.sendPrepare(pID, tx)
+		} else {
+			c.send(pID, tx.decision, tx.ID)
+		}
+		tx.Retries++
+	}
+	tx.retry = c.Clock.AfterFunc(c.RetryInterval, func() { c.onRetry(tx) })
+}
+
+func (c *Coordinator) onTimeout(tx *coordTxn, phase coordPhase) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.txns[tx.ID] != tx || tx.phase != phase || tx.timeout == nil {
+		return
+	}
+	if phase == phaseOnePhase {
+		// The participant may still commit, so ask it to abort instead and
+		// report whatever it answers. The Abort is resent until it does.
+		log.Printf("[Coordinator] Timeout waiting for the one-phase outcome of Tx %s, asking for an abort", tx.ID)
+		tx.aborted = true
+		tx.timeout = nil
+		c.sendPrepare(tx.Participants[0], tx)
+		return
+	}
+	if phase == phaseVoting {
+		log.Printf("[Coordinator] Timeout waiting for votes in Tx %s", tx.ID)
+		tx.aborted = true
+		c.decide(tx)
+		return
+	}
+	log.Printf("[Coordinator] Timeout waiting for ACKs in Tx %s", tx.ID)
+	c.complete(tx, !tx.aborted)
+}
+
+// decide ends Phase 1: it makes the decision durable and starts Phase 2
+func (c *Coordinator) decide(tx *coordTxn) {
+	c.stopTimers(tx)
+	tx.Voting = c.Clock.Since(tx.start)
+
+	// Read-only participants have already released everything and take no part in Phase 2
+	var phase2 []string
+	for _, pID := range tx.Participants {
+		if !tx.readOnly[pID] {
+			phase2 = append(phase2, pID)
 		}
 	}
+	if !tx.aborted && len(phase2) == 0 {
+		log.Printf("[Coordinator] Tx %s is read-only everywhere, skipping Phase 2", tx.ID)
+		c.complete(tx, true)
+		return
+	}
+
+	if c.crashAt(CrashReady) {
+		return
+	}
 
 	// Phase 2: Decision
 	decision := protocol.MsgCommit
-	if aborted {
+	if tx.aborted {
 		decision = protocol.MsgAbort
 	}
 
-	log.Printf("[Coordinator] Decision for Tx %s: %s", txID, decision)
-	c.broadcast(decision, txID)
+	// The decision must be durable before anyone hears about it
+	if !c.logDecision(tx.ID, decision, phase2) && !tx.aborted {
+		// Nobody has been told to commit yet, so aborting is still safe
+		tx.aborted = true
+		decision = protocol.MsgAbort
+		c.logDecision(tx.ID, decision, phase2)
+	}
 
-	// Wait for Acks
-	pendingAcks := make(map[string]bool)
-	for _, p := range c.Participants {
-		pendingAcks[p] = true
+	if c.crashAt(CrashDecision) {
+		return
 	}
 
-	ctxAck, cancelAck := context.WithTimeout(context.Background(), c.Timeout)
-	defer cancelAck()
+	log.Printf("[Coordinator] Decision for Tx %s: %s", tx.ID, decision)
+	c.startPhase2(tx, decision, phase2)
+}
 
-	// Reuse ticker for Phase 2 retries
-AckLoop:
-	for len(pendingAcks) > 0 {
-		select {
-		case <-ctxAck.Done():
-			log.Printf("[Coordinator] Timeout waiting for ACKs in Tx %s", txID)
-			break AckLoop
-		case <-ticker.C:
-			// Retry Decision
-			for pID := range pendingAcks {
-				sendTo(pID, decision)
-			}
-		case msg := <-c.Inbox:
-			if msg.TransactionID != txID {
-				continue
-			}
-			if msg.Type == protocol.MsgAck {
-				delete(pendingAcks, msg.FromID)
+// logDecision writes the decision to the log, forcing it unless the mode
+// presumes it, and records it in the decision table
+func (c *Coordinator) logDecision(txID uuid.UUID, decision protocol.MessageType, participants []string) bool {
+	recType := wal.RecCommit
+	if decision == protocol.MsgAbort {
+		recType = wal.RecAbort
+	}
+	rec := wal.Record{Type: recType, TransactionID: txID, Participants: participants}
+	if err := c.Log.Append(rec, c.Mode.coordinatorForces(decision)); err != nil {
+		log.Printf("[Coordinator] Failed to log decision for Tx %s: %v", txID, err)
+		return false
+	}
+	c.decisions[txID] = decision
+	return true
+}
+
+// startPhase2 broadcasts the decision and, unless the mode presumes it,
+// collects acknowledgements with retries until the timeout expires
+func (c *Coordinator) startPhase2(tx *coordTxn, decision protocol.MessageType, participants []string) {
+	tx.phase = phaseAcking
+	tx.decision = decision
+	tx.decided = c.Clock.Now()
+	c.broadcast(decision, tx.ID, participants)
+	if !c.Mode.acked(decision) {
+		// The outcome is presumed, so there is nothing to wait for; a
+		// participant that missed the message will ask
+		c.complete(tx, !tx.aborted)
+		return
+	}
+
+	tx.pending = make(map[string]bool)
+	for _, p := range participants {
+		tx.pending[p] = true
+	}
+	c.arm(tx)
+}
+
+// complete forgets a finished transaction and hands its outcome to the caller.
+// Its decision is dropped after Retention unless a participant that missed it
+// may still ask.
+func (c *Coordinator) complete(tx *coordTxn, committed bool) {
+	c.stopTimers(tx)
+	if tx.phase == phaseAcking {
+		tx.Acking = c.Clock.Since(tx.decided)
+	}
+	delete(c.txns, tx.ID)
+	if decision, ok := c.decisions[tx.ID]; ok && (tx.ended || decision == c.Mode.presumed()) && c.Retention > 0 {
+		stop := c.stop
+		c.Clock.AfterFunc(c.Retention, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.stop == stop {
+				delete(c.decisions, tx.ID)
 			}
-		}
+		})
 	}
+	tx.resolve(committed)
+}
 
-	duration := time.Since(startTime)
-	return !aborted, duration
+func (c *Coordinator) send(to string, msgType protocol.MessageType, txID uuid.UUID) {
+	c.Net.Send(protocol.Message{
+		Type:          msgType,
+		TransactionID: txID,
+		FromID:        c.ID,
+		ToID:          to,
+	})
 }
 
-func (c *Coordinator) broadcast(msgType protocol.MessageType, txID uuid.UUID) {
-	for _, pID := range c.Participants {
-		msg := protocol.Message{
-			Type:          msgType,
-			TransactionID: txID,
+// sendPrepare hands a participant its share of the work and asks it to vote,
+// telling it who else takes part; in one phase it asks it to commit instead,
+// or to abort once we have given up waiting
+func (c *Coordinator) sendPrepare(to string, tx *coordTxn) {
+	if tx.phase == phaseOnePhase && tx.aborted {
+		c.Net.Send(protocol.Message{
+			Type:          protocol.MsgAbort,
+			TransactionID: tx.ID,
 			FromID:        c.ID,
-			ToID:          pID,
+			ToID:          to,
+			OnePhase:      true,
+		})
+		return
+	}
+	msgType := protocol.MsgPrepare
+	if tx.phase == phaseOnePhase {
+		msgType = protocol.MsgCommitOnePhase
+	}
+	c.Net.Send(protocol.Message{
+		Type:          msgType,
+		TransactionID: tx.ID,
+		FromID:        c.ID,
+		ToID:          to,
+		Participants:  tx.Participants,
+		Operations:    tx.ops[to],
+		Timestamp:     tx.start.UnixNano(),
+	})
+}
+
+// route splits ops by owner. Participants are returned in the order of
+// c.Participants, each with its operations in their original order. A key
+// owned by none of them is an error, as its operation would never run.
+func (c *Coordinator) route(ops []protocol.Operation) ([]string, map[string][]protocol.Operation, error) {
+	byOwner := make(map[string][]protocol.Operation)
+	for _, op := range ops {
+		owner := c.owner(op.Key)
+		byOwner[owner] = append(byOwner[owner], op)
+	}
+	var participants []string
+	for _, pID := range c.Participants {
+		if _, ok := byOwner[pID]; ok {
+			participants = append(participants, pID)
 		}
-		c.Net.Send(msg)
+	}
+	if len(participants) < len(byOwner) {
+		for _, op := range ops {
+			if owner := c.owner(op.Key); !slices.Contains(participants, owner) {
+				return nil, nil, fmt.Errorf("key %q is owned by %q, which is not a participant", op.Key, owner)
+			}
+		}
+	}
+	return participants, byOwner, nil
+}
+
+func (c *Coordinator) owner(key string) string {
+	if c.Owner != nil {
+		return c.Owner(key)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.Participants[h.Sum32()%uint32(len(c.Participants))]
+}
+
+func (c *Coordinator) broadcast(msgType protocol.MessageType, txID uuid.UUID, participants []string) {
+	for _, pID := range participants {
+		c.send(pID, msgType, txID)
 	}
 }
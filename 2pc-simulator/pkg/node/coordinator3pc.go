@@ -0,0 +1,283 @@
+package node
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/protocol"
+	"2pc-sim/pkg/transport"
+	"2pc-sim/pkg/wal"
+)
+
+// Coordinator3PC drives the Three-Phase Commit protocol:
+// CanCommit (vote) -> PreCommit -> DoCommit.
+type Coordinator3PC struct {
+	ID            string
+	Net           transport.Network
+	Participants  []string
+	Inbox         chan protocol.Message
+	Timeout       time.Duration
+	RetryInterval time.Duration
+	Log           wal.Log
+	// Clock times the transactions and drives the retry and timeout timers
+	Clock clock.Clock
+	mu    sync.Mutex
+	txns  map[uuid.UUID]*coordTxn3PC // transactions in progress
+	stop  chan struct{}
+}
+
+// phase3PC is the round a 3PC transaction is waiting on
+type phase3PC int
+
+const (
+	phaseCanCommit phase3PC = iota
+	phasePreCommit
+	phaseDoCommit
+	phaseAbort
+)
+
+// coordTxn3PC is the coordinator's state machine for one 3PC transaction.
+// Its fields are guarded by the coordinator's mutex.
+type coordTxn3PC struct {
+	*Txn
+	phase   phase3PC
+	pending map[string]bool // participants that still owe an answer
+	decided time.Time       // when DoCommit or Abort started
+	retry   clock.Timer
+	timeout clock.Timer
+}
+
+func NewCoordinator3PC(id string, net transport.Network, participants []string, timeout time.Duration, retryInterval time.Duration) *Coordinator3PC {
+	return &Coordinator3PC{
+		ID:            id,
+		Net:           net,
+		Participants:  participants,
+		Inbox:         make(chan protocol.Message, 100),
+		Timeout:       timeout,
+		RetryInterval: retryInterval,
+		Log:           wal.NewMemoryLog(0),
+		Clock:         clock.Real{},
+		txns:          make(map[uuid.UUID]*coordTxn3PC),
+		stop:          make(chan struct{}),
+	}
+}
+
+func (c *Coordinator3PC) Start() {
+	c.Net.Register(c.ID, c.Inbox)
+	serve(c.Clock, c.stop, c.Inbox, func(msg protocol.Message) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.handleMessage(msg)
+	})
+}
+
+// RunTransaction executes a 3PC transaction and waits for its outcome
+// Returns true if committed, false if aborted
+func (c *Coordinator3PC) RunTransaction() (bool, time.Duration) {
+	return c.Begin().Wait()
+}
+
+// Begin starts a 3PC transaction without waiting for it
+func (c *Coordinator3PC) Begin() *Txn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx := &coordTxn3PC{Txn: newTxn(c.Clock)}
+	tx.Participants = c.Participants
+	c.txns[tx.ID] = tx
+
+	log.Printf("[Coordinator3PC] Starting Tx %s", tx.ID)
+	if err := c.Log.Append(wal.Record{Type: wal.RecBegin, TransactionID: tx.ID, Participants: c.Participants}, false); err != nil {
+		log.Printf("[Coordinator3PC] Failed to log start of Tx %s: %v", tx.ID, err)
+	}
+	c.startPhase(tx, phaseCanCommit)
+	return tx.Txn
+}
+
+// handleMessage hands a participant's answer to the transaction it belongs
+// to. The caller must hold c.mu.
+func (c *Coordinator3PC) handleMessage(msg protocol.Message) {
+	tx, ok := c.txns[msg.TransactionID]
+	if !ok || !tx.pending[msg.FromID] {
+		return
+	}
+
+	switch tx.phase {
+	case phaseCanCommit:
+		// Any No vote aborts; nobody can have been sent PreCommit yet
+		switch msg.Type {
+		case protocol.MsgVoteNo:
+			log.Printf("[Coordinator3PC] Received VoteNo from %s", msg.FromID)
+			c.abort(tx)
+			return
+		case protocol.MsgVoteYes:
+			delete(tx.pending, msg.FromID)
+		}
+		if len(tx.pending) == 0 {
+			c.startPhase(tx, phasePreCommit)
+		}
+	case phasePreCommit:
+		switch msg.Type {
+		case protocol.MsgVoteNo:
+			// The participant aborted through the termination protocol, which
+			// it only does when nobody has passed Ready
+			log.Printf("[Coordinator3PC] %s refused PreCommit, having aborted", msg.FromID)
+			c.abort(tx)
+			return
+		case protocol.MsgPreCommitAck:
+			delete(tx.pending, msg.FromID)
+		}
+		if len(tx.pending) == 0 {
+			c.commit(tx)
+		}
+	case phaseDoCommit, phaseAbort:
+		if msg.Type != protocol.MsgAck {
+			return
+		}
+		delete(tx.pending, msg.FromID)
+		if len(tx.pending) > 0 {
+			return
+		}
+		if tx.phase == phaseDoCommit {
+			if err := c.Log.Append(wal.Record{Type: wal.RecEnd, TransactionID: tx.ID}, false); err != nil {
+				log.Printf("[Coordinator3PC] Failed to log end of Tx %s: %v", tx.ID, err)
+			}
+		}
+		c.complete(tx, tx.phase == phaseDoCommit)
+	}
+}
+
+// commit decides Commit once every participant has acknowledged PreCommit:
+// only then is nobody left who could abort
+func (c *Coordinator3PC) commit(tx *coordTxn3PC) {
+	if err := c.Log.Append(wal.Record{Type: wal.RecCommit, TransactionID: tx.ID, Participants: c.Participants}, true); err != nil {
+		log.Printf("[Coordinator3PC] Failed to log decision for Tx %s: %v", tx.ID, err)
+	}
+	log.Printf("[Coordinator3PC] Decision for Tx %s: Commit", tx.ID)
+	c.decide(tx)
+	c.startPhase(tx, phaseDoCommit)
+}
+
+func (c *Coordinator3PC) abort(tx *coordTxn3PC) {
+	if err := c.Log.Append(wal.Record{Type: wal.RecAbort, TransactionID: tx.ID, Participants: c.Participants}, true); err != nil {
+		log.Printf("[Coordinator3PC] Failed to log decision for Tx %s: %v", tx.ID, err)
+	}
+	log.Printf("[Coordinator3PC] Decision for Tx %s: Abort", tx.ID)
+	c.decide(tx)
+	c.startPhase(tx, phaseAbort)
+}
+
+// decide splits the transaction's time between reaching the decision and
+// handing it out
+func (c *Coordinator3PC) decide(tx *coordTxn3PC) {
+	tx.Voting = c.Clock.Since(tx.start)
+	tx.decided = c.Clock.Now()
+}
+
+// startPhase sends the phase's message to every participant and waits for
+// their answers
+func (c *Coordinator3PC) startPhase(tx *coordTxn3PC, phase phase3PC) {
+	tx.phase = phase
+	tx.pending = make(map[string]bool)
+	for _, pID := range c.Participants {
+		tx.pending[pID] = true
+		c.send(pID, tx)
+	}
+	c.arm(tx)
+}
+
+// arm restarts the retry and timeout timers for the transaction's current
+// phase. PreCommit has no timeout: once it may have reached anyone, aborting
+// could split the outcome, so it is resent until everyone has it.
+func (c *Coordinator3PC) arm(tx *coordTxn3PC) {
+	c.stopTimers(tx)
+	phase := tx.phase
+	tx.retry = c.Clock.AfterFunc(c.RetryInterval, func() { c.onRetry(tx) })
+	if phase != phasePreCommit {
+		tx.timeout = c.Clock.AfterFunc(c.Timeout, func() { c.onTimeout(tx, phase) })
+	}
+}
+
+func (c *Coordinator3PC) stopTimers(tx *coordTxn3PC) {
+	if tx.retry != nil {
+		tx.retry.Stop()
+		tx.retry = nil
+	}
+	if tx.timeout != nil {
+		tx.timeout.Stop()
+		tx.timeout = nil
+	}
+}
+
+// onRetry resends the phase's message to everyone who has not answered
+func (c *Coordinator3PC) onRetry(tx *coordTxn3PC) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.txns[tx.ID] != tx || tx.retry == nil {
+		return
+	}
+	// In participant order, so that a seeded simulation replays the same sends
+	for _, pID := range c.Participants {
+		if tx.pending[pID] {
+			c.send(pID, tx)
+			tx.Retries++
+		}
+	}
+	tx.retry = c.Clock.AfterFunc(c.RetryInterval, func() { c.onRetry(tx) })
+}
+
+func (c *Coordinator3PC) onTimeout(tx *coordTxn3PC, phase phase3PC) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.txns[tx.ID] != tx || tx.phase != phase || tx.timeout == nil {
+		return
+	}
+	switch phase {
+	case phaseCanCommit:
+		log.Printf("[Coordinator3PC] Timeout waiting for votes in Tx %s", tx.ID)
+		c.abort(tx)
+	case phaseDoCommit:
+		// Everyone is PreCommitted and commits on its own timeout
+		log.Printf("[Coordinator3PC] Timeout waiting for ACKs in Tx %s", tx.ID)
+		c.complete(tx, true)
+	case phaseAbort:
+		log.Printf("[Coordinator3PC] Timeout waiting for ACKs in Tx %s", tx.ID)
+		c.complete(tx, false)
+	}
+}
+
+// complete forgets a finished transaction and hands its outcome to the caller
+func (c *Coordinator3PC) complete(tx *coordTxn3PC, committed bool) {
+	c.stopTimers(tx)
+	if !tx.decided.IsZero() {
+		tx.Acking = c.Clock.Since(tx.decided)
+	}
+	delete(c.txns, tx.ID)
+	tx.resolve(committed)
+}
+
+// send hands a participant the phase's message; CanCommit tells it who else
+// takes part, for the termination protocol
+func (c *Coordinator3PC) send(to string, tx *coordTxn3PC) {
+	msg := protocol.Message{
+		TransactionID: tx.ID,
+		FromID:        c.ID,
+		ToID:          to,
+	}
+	switch tx.phase {
+	case phaseCanCommit:
+		msg.Type = protocol.MsgCanCommit
+		msg.Participants = c.Participants
+	case phasePreCommit:
+		msg.Type = protocol.MsgPreCommit
+	case phaseDoCommit:
+		msg.Type = protocol.MsgDoCommit
+	case phaseAbort:
+		msg.Type = protocol.MsgAbort
+	}
+	c.Net.Send(msg)
+}
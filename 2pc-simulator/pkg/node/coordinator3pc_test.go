@@ -0,0 +1,212 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/protocol"
+	"2pc-sim/pkg/sim"
+	"2pc-sim/pkg/transport"
+)
+
+func TestCoordinator3PCCommit(t *testing.T) {
+	net := NewMockNetwork()
+	pID := "p1"
+	pChan := make(chan protocol.Message, 10)
+	net.Register(pID, pChan)
+
+	coordID := "coord"
+	coord := NewCoordinator3PC(coordID, net, []string{pID}, 1*time.Second, 50*time.Millisecond)
+	coord.Clock = clock.NewFake(time.Time{})
+	coord.Start()
+
+	done := make(chan bool)
+	go func() {
+		committed, _ := coord.RunTransaction()
+		done <- committed
+	}()
+
+	// Answer each phase in order
+	steps := []struct {
+		expect protocol.MessageType
+		reply  protocol.MessageType
+	}{
+		{protocol.MsgCanCommit, protocol.MsgVoteYes},
+		{protocol.MsgPreCommit, protocol.MsgPreCommitAck},
+		{protocol.MsgDoCommit, protocol.MsgAck},
+	}
+	for _, step := range steps {
+		msg := <-pChan
+		if msg.Type != step.expect {
+			t.Fatalf("Expected %s, got %s", step.expect, msg.Type)
+		}
+		coord.Inbox <- protocol.Message{Type: step.reply, TransactionID: msg.TransactionID, FromID: pID, ToID: coordID}
+	}
+
+	select {
+	case committed := <-done:
+		if !committed {
+			t.Error("Transaction aborted, expected Commit")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Timeout waiting for RunTransaction")
+	}
+}
+
+func TestCoordinator3PCAbortOnNo(t *testing.T) {
+	net := NewMockNetwork()
+	pID := "p1"
+	pChan := make(chan protocol.Message, 10)
+	net.Register(pID, pChan)
+
+	coordID := "coord"
+	coord := NewCoordinator3PC(coordID, net, []string{pID}, 1*time.Second, 50*time.Millisecond)
+	coord.Clock = clock.NewFake(time.Time{})
+	coord.Start()
+
+	done := make(chan bool)
+	go func() {
+		committed, _ := coord.RunTransaction()
+		done <- committed
+	}()
+
+	msg := <-pChan
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteNo, TransactionID: msg.TransactionID, FromID: pID, ToID: coordID}
+
+	if msg = <-pChan; msg.Type != protocol.MsgAbort {
+		t.Errorf("Expected Abort, got %s", msg.Type)
+	}
+	coord.Inbox <- protocol.Message{Type: protocol.MsgAck, TransactionID: msg.TransactionID, FromID: pID, ToID: coordID}
+
+	if <-done {
+		t.Error("Transaction committed, expected Abort")
+	}
+}
+
+func TestCoordinator3PCTimeout(t *testing.T) {
+	net := NewMockNetwork()
+	pID := "p1"
+	pChan := make(chan protocol.Message, 10)
+	net.Register(pID, pChan)
+
+	coordID := "coord"
+	coord := NewCoordinator3PC(coordID, net, []string{pID}, 100*time.Millisecond, time.Hour)
+	clk := clock.NewFake(time.Time{})
+	coord.Clock = clk
+	coord.Start()
+
+	tx := coord.Begin()
+	if msg := <-pChan; msg.Type != protocol.MsgCanCommit {
+		t.Fatalf("Expected CanCommit, got %s", msg.Type)
+	}
+
+	// The vote never comes; wait for the exchange to arm its timeout and retry
+	clk.BlockUntil(2)
+	clk.Advance(100 * time.Millisecond)
+	if msg := <-pChan; msg.Type != protocol.MsgAbort {
+		t.Fatalf("Expected Abort after the vote timeout, got %s", msg.Type)
+	}
+
+	// Nor does the Ack
+	clk.BlockUntil(2)
+	clk.Advance(100 * time.Millisecond)
+	if committed, d := tx.Wait(); committed || d != 200*time.Millisecond {
+		t.Errorf("Expected an Abort after 200ms, got committed=%v after %v", committed, d)
+	}
+}
+
+func TestCoordinator3PCWaitsForPreCommitAcks(t *testing.T) {
+	net := NewMockNetwork()
+	coord := NewCoordinator3PC("coord", net, []string{"p1", "p2"}, 100*time.Millisecond, 30*time.Millisecond)
+	clk := clock.NewFake(time.Time{})
+	coord.Clock = clk
+
+	tx := coord.Begin()
+	for _, pID := range []string{"p1", "p2"} {
+		coord.handleMessage(protocol.Message{Type: protocol.MsgVoteYes, TransactionID: tx.ID, FromID: pID, ToID: "coord"})
+	}
+	coord.handleMessage(protocol.Message{Type: protocol.MsgPreCommitAck, TransactionID: tx.ID, FromID: "p1", ToID: "coord"})
+
+	// Well past the timeout, p2's Ack is still missing: keep resending
+	// PreCommit rather than decide either way
+	net.SentMessages = nil
+	clk.Advance(time.Second)
+	for _, msg := range net.SentMessages {
+		if msg.Type != protocol.MsgPreCommit || msg.ToID != "p2" {
+			t.Fatalf("Expected only PreCommit resent to p2, got %v", msg)
+		}
+	}
+	if len(net.SentMessages) == 0 {
+		t.Fatal("Expected PreCommit resent to p2")
+	}
+	if _, ok := coord.txns[tx.ID]; !ok {
+		t.Fatal("Transaction should still be waiting on PreCommit")
+	}
+
+	// p2 aborted with its peers, so the coordinator aborts too
+	net.SentMessages = nil
+	coord.handleMessage(protocol.Message{Type: protocol.MsgVoteNo, TransactionID: tx.ID, FromID: "p2", ToID: "coord"})
+	if len(net.SentMessages) != 2 || net.SentMessages[0].Type != protocol.MsgAbort {
+		t.Fatalf("Expected Abort sent to both, got %v", net.SentMessages)
+	}
+	clk.Advance(100 * time.Millisecond)
+	if committed, _ := tx.Wait(); committed {
+		t.Error("Transaction committed, expected Abort")
+	}
+}
+
+func Test3PCParticipantsAgreeUnderDrops(t *testing.T) {
+	outcomes := make(map[bool]int)
+	for seed := int64(1); seed <= 400; seed++ {
+		sched := sim.NewScheduler(seed)
+		net := transport.NewSimulatedNetwork(5*time.Millisecond, 0.5, 0.5)
+		net.Clock = sched
+		net.Seed(seed)
+
+		// Participants give up on the coordinator as soon as it gives up on
+		// them, which is when a unilateral abort used to split the outcome
+		pIDs := []string{"p1", "p2", "p3"}
+		var participants []*Participant3PC
+		for _, id := range pIDs {
+			p := NewParticipant3PC(id, net, "coord", 100*time.Millisecond)
+			p.Clock = sched
+			p.Retention = 0
+			p.Start()
+			participants = append(participants, p)
+		}
+		coord := NewCoordinator3PC("coord", net, pIDs, 100*time.Millisecond, 30*time.Millisecond)
+		coord.Clock = sched
+		coord.Start()
+
+		var txns []*Txn
+		for i := 0; i < 5; i++ {
+			txns = append(txns, coord.Begin())
+		}
+		sched.Run()
+
+		for _, tx := range txns {
+			select {
+			case <-tx.Done():
+			default:
+				t.Fatalf("Seed %d: Tx %s never finished", seed, tx.ID)
+			}
+			committed, _ := tx.Wait()
+			outcomes[committed]++
+			want := protocol.StateAborted
+			if committed {
+				want = protocol.StateCommitted
+			}
+			for _, p := range participants {
+				got := p.State(tx.ID)
+				// One that never heard of an aborted transaction has nothing to undo
+				if got != want && !(got == protocol.StateInit && !committed) {
+					t.Errorf("Seed %d: %s is %s in Tx %s, but the coordinator reported %s", seed, p.ID, got, tx.ID, want)
+				}
+			}
+		}
+	}
+	if outcomes[true] == 0 || outcomes[false] == 0 {
+		t.Errorf("Expected both commits and aborts, got %v", outcomes)
+	}
+}
@@ -4,7 +4,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/lock"
+	"2pc-sim/pkg/partition"
 	"2pc-sim/pkg/protocol"
+	"2pc-sim/pkg/sim"
+	"2pc-sim/pkg/transport"
+	"2pc-sim/pkg/wal"
 )
 
 func TestCoordinatorCommit(t *testing.T) {
@@ -15,6 +23,8 @@ func TestCoordinatorCommit(t *testing.T) {
 
 	coordID := "coord"
 	coord := NewCoordinator(coordID, net, []string{pID}, 1*time.Second, 10*time.Millisecond)
+	// Time stands still, so nothing is retried behind the test's back
+	coord.Clock = clock.NewFake(time.Time{})
 	coord.Start()
 
 	// Simulate Coordinator Logic in a goroutine because it blocks
@@ -49,12 +59,7 @@ func TestCoordinatorCommit(t *testing.T) {
 	select {
 	case msg := <-pChan:
 		if msg.Type != protocol.MsgCommit {
-			// Might be a retry of Prepare if it was fast, but expected Commit next
-			if msg.Type == protocol.MsgPrepare {
-				// Ignore duplicate Prepare
-			} else {
-				t.Errorf("Expected Commit, got %v", msg.Type)
-			}
+			t.Errorf("Expected Commit, got %v", msg.Type)
 		}
 		// Send Ack
 		coord.Inbox <- protocol.Message{
@@ -136,24 +141,651 @@ func TestCoordinatorPrepareRetry(t *testing.T) {
 
 	coordID := "coord"
 	coord := NewCoordinator(coordID, net, []string{pID}, 200*time.Millisecond, 50*time.Millisecond)
+	clk := clock.NewFake(time.Time{})
+	coord.Clock = clk
+	coord.Start()
+
+	count := func(msgType protocol.MessageType) int {
+		net.mu.Lock()
+		defer net.mu.Unlock()
+		n := 0
+		for _, msg := range net.SentMessages {
+			if msg.Type == msgType {
+				n++
+			}
+		}
+		return n
+	}
+
+	// The transaction will time out because we never reply
+	tx := coord.Begin()
+	if got := count(protocol.MsgPrepare); got != 1 {
+		t.Fatalf("Expected one Prepare before any time passes, got %d", got)
+	}
+
+	// Resent at 50, 100 and 150ms; the timeout at 200ms comes first
+	clk.Advance(200 * time.Millisecond)
+	if got := count(protocol.MsgPrepare); got != 4 {
+		t.Errorf("Expected 4 Prepare messages (3 retries), got %d", got)
+	}
+	if count(protocol.MsgAbort) != 1 {
+		t.Errorf("Expected the vote timeout to send Abort, got %d", count(protocol.MsgAbort))
+	}
+
+	// The Abort is never acknowledged either
+	clk.Advance(200 * time.Millisecond)
+	select {
+	case <-tx.Done():
+	default:
+		t.Fatal("Transaction should be over once the Ack timeout expires")
+	}
+	if committed, d := tx.Wait(); committed || d != 400*time.Millisecond {
+		t.Errorf("Expected an Abort after 400ms, got committed=%v after %v", committed, d)
+	}
+}
+
+func TestCoordinatorRecoverResumesCommit(t *testing.T) {
+	net := NewMockNetwork()
+	pID := "p1"
+	pChan := make(chan protocol.Message, 10)
+	net.Register(pID, pChan)
+
+	coordID := "coord"
+	coord := NewCoordinator(coordID, net, []string{pID}, 1*time.Second, 50*time.Millisecond)
+	coord.CrashPoint = CrashDecision
+	coord.Start()
+
+	tx := coord.Begin()
+	prepare := <-pChan
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteYes, TransactionID: prepare.TransactionID, FromID: pID, ToID: coordID}
+
+	deadline := time.Now().Add(time.Second)
+	for !coord.Crashed() {
+		if time.Now().After(deadline) {
+			t.Fatal("The coordinator should crash once it has logged the decision")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-tx.Done():
+		t.Error("A logged Commit should stay open across the crash, not report an abort")
+	default:
+	}
+	if _, ok := coord.Decision(prepare.TransactionID); ok {
+		t.Error("Crash should clear the in-memory decision table")
+	}
+
+	// The decision was logged before the crash, so recovery must finish the commit
+	go func() {
+		for msg := range pChan {
+			if msg.Type == protocol.MsgCommit {
+				coord.Inbox <- protocol.Message{Type: protocol.MsgAck, TransactionID: msg.TransactionID, FromID: pID, ToID: coordID}
+				return
+			}
+		}
+	}()
+
+	outcomes, err := coord.Recover()
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if committed, ok := outcomes[prepare.TransactionID]; !ok || !committed {
+		t.Errorf("Expected Tx %s to be committed on recovery, got %v", prepare.TransactionID, outcomes)
+	}
+	if d, _ := coord.Decision(prepare.TransactionID); d != protocol.MsgCommit {
+		t.Errorf("Expected recovered decision Commit, got %s", d)
+	}
+	if committed, _ := tx.Wait(); !committed {
+		t.Error("The caller's handle should report the recovered commit")
+	}
+}
+
+func TestCoordinatorRestartsAfterDowntime(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		onePhase   bool
+		crashPoint CrashPoint
+		// How many transactions are reported committed and aborted
+		commits, aborts int
+	}{
+		// One transaction's Commit is logged when the crash hits; the other
+		// has not been decided, so it ends as an abort
+		{"logged commit", false, CrashDecision, 1, 1},
+		// The only participant committed on its own while we were down
+		{"one phase", true, CrashPrepare, 1, 0},
+	} {
+		sched := sim.NewScheduler(1)
+		net := transport.NewSimulatedNetwork(time.Millisecond, 0, 0)
+		net.Clock = sched
+		var participants []*Participant
+		for _, id := range []string{"p1", "p2"} {
+			p := NewParticipant(id, net, "coord")
+			p.Clock = sched
+			p.Locks.Clock = sched
+			p.Retention = 0
+			p.Start()
+			participants = append(participants, p)
+		}
+		coord := NewCoordinator("coord", net, []string{"p1", "p2"}, time.Second, 50*time.Millisecond)
+		coord.Clock = sched
+		coord.CrashPoint = tc.crashPoint
+		coord.CrashDowntime = 500 * time.Millisecond
+		coord.Start()
+
+		var txns []*Txn
+		if tc.onePhase {
+			coord.OnePhase = true
+			coord.Owner = func(string) string { return "p1" }
+			txns = append(txns, coord.BeginOps([]protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "x"}}))
+		} else {
+			txns = append(txns, coord.Begin(), coord.Begin())
+		}
+		sched.Run()
+
+		outcomes := make(map[bool]int)
+		for i, tx := range txns {
+			committed, d := tx.Wait()
+			outcomes[committed]++
+			if committed && d < coord.CrashDowntime {
+				t.Errorf("%s: a commit finished by the restart cannot take less than the downtime, took %v", tc.name, d)
+			}
+			want := protocol.StateAborted
+			if committed {
+				want = protocol.StateCommitted
+			}
+			for _, p := range participants {
+				if got := p.State(tx.ID); got != want && got != protocol.StateInit {
+					t.Errorf("%s: %s is %s in Tx %d, but it was reported %s", tc.name, p.ID, got, i, want)
+				}
+			}
+		}
+		if outcomes[true] != tc.commits || outcomes[false] != tc.aborts {
+			t.Errorf("%s: expected %d commit(s) and %d abort(s), got %v", tc.name, tc.commits, tc.aborts, outcomes)
+		}
+	}
+}
+
+func TestCoordinatorForgetsSettledDecisions(t *testing.T) {
+	net := NewMockNetwork()
+	coord := NewCoordinator("coord", net, []string{"p1"}, time.Second, 50*time.Millisecond)
+	clk := clock.NewFake(time.Time{})
+	coord.Clock = clk
+	deliver := func(msgType protocol.MessageType, txID uuid.UUID) {
+		coord.mu.Lock()
+		defer coord.mu.Unlock()
+		coord.handleMessage(protocol.Message{Type: msgType, TransactionID: txID, FromID: "p1", ToID: "coord"})
+	}
+
+	// Acknowledged by everyone: nobody will ask again
+	acked := coord.Begin()
+	deliver(protocol.MsgVoteYes, acked.ID)
+	deliver(protocol.MsgAck, acked.ID)
+	// Never acknowledged: p1 may still ask once the Ack timeout is over
+	unacked := coord.Begin()
+	deliver(protocol.MsgVoteYes, unacked.ID)
+
+	clk.Advance(time.Second)
+	if _, ok := coord.Decision(acked.ID); !ok {
+		t.Error("The decision should be kept for Retention")
+	}
+	clk.Advance(coord.Retention)
+	if _, ok := coord.Decision(acked.ID); ok {
+		t.Error("An acknowledged decision should be dropped after Retention")
+	}
+	if d, _ := coord.Decision(unacked.ID); d != protocol.MsgCommit {
+		t.Errorf("An unacknowledged Commit must be kept for inquiries, got %s", d)
+	}
+}
+
+func TestCoordinatorWoundAbortsUndecided(t *testing.T) {
+	net := NewMockNetwork()
+	coord := NewCoordinator("coord", net, []string{"p1", "p2"}, time.Second, 50*time.Millisecond)
+	coord.Clock = clock.NewFake(time.Time{})
+	deliver := func(msgType protocol.MessageType, txID uuid.UUID, from string) {
+		coord.mu.Lock()
+		defer coord.mu.Unlock()
+		coord.handleMessage(protocol.Message{Type: msgType, TransactionID: txID, FromID: from, ToID: "coord"})
+	}
+
+	// Wounded while p2 has yet to vote: nothing is decided, so it aborts
+	wounded := coord.Begin()
+	deliver(protocol.MsgVoteYes, wounded.ID, "p1")
+	deliver(protocol.MsgWound, wounded.ID, "p1")
+	if d, _ := coord.Decision(wounded.ID); d != protocol.MsgAbort {
+		t.Errorf("Expected a wound to abort an undecided transaction, got %s", d)
+	}
+
+	// Wounded after the decision: the Commit stands
+	decided := coord.Begin()
+	deliver(protocol.MsgVoteYes, decided.ID, "p1")
+	deliver(protocol.MsgVoteYes, decided.ID, "p2")
+	deliver(protocol.MsgWound, decided.ID, "p1")
+	if d, _ := coord.Decision(decided.ID); d != protocol.MsgCommit {
+		t.Errorf("A wound after the decision should change nothing, got %s", d)
+	}
+}
+
+func TestCoordinatorRecoverAbortsUndecided(t *testing.T) {
+	net := NewMockNetwork()
+	pID := "p1"
+	pChan := make(chan protocol.Message, 10)
+	net.Register(pID, pChan)
+
+	coordID := "coord"
+	coord := NewCoordinator(coordID, net, []string{pID}, 200*time.Millisecond, 50*time.Millisecond)
+	coord.CrashPoint = CrashPrepare
+	coord.Start()
+
+	coord.RunTransaction()
+	prepare := <-pChan
+
+	go func() {
+		for msg := range pChan {
+			if msg.Type == protocol.MsgAbort {
+				coord.Inbox <- protocol.Message{Type: protocol.MsgAck, TransactionID: msg.TransactionID, FromID: pID, ToID: coordID}
+				return
+			}
+		}
+	}()
+
+	outcomes, err := coord.Recover()
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if committed, ok := outcomes[prepare.TransactionID]; !ok || committed {
+		t.Errorf("Expected undecided Tx %s to abort on recovery, got %v", prepare.TransactionID, outcomes)
+	}
+}
+
+func TestCoordinatorRestartWhileUp(t *testing.T) {
+	coord := NewCoordinator("coord", NewMockNetwork(), []string{"p1"}, 200*time.Millisecond, 50*time.Millisecond)
+	coord.Start()
+
+	if coord.Crashed() {
+		t.Fatal("A started coordinator should not report a crash")
+	}
+	if _, err := coord.Restart(); err == nil {
+		t.Error("Restart of a coordinator that is up should fail")
+	}
+	if _, err := coord.Recover(); err == nil {
+		t.Error("Recover of a coordinator that is up should fail")
+	}
+}
+
+func TestCoordinatorAnswersInquiry(t *testing.T) {
+	net := NewMockNetwork()
+	pID := "p1"
+	pChan := make(chan protocol.Message, 10)
+	net.Register(pID, pChan)
+
+	coordID := "coord"
+	coord := NewCoordinator(coordID, net, []string{pID}, 1*time.Second, 50*time.Millisecond)
+	coord.Clock = clock.NewFake(time.Time{})
+	coord.Start()
+
+	done := make(chan bool)
+	go func() {
+		committed, _ := coord.RunTransaction()
+		done <- committed
+	}()
+
+	prepare := <-pChan
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteYes, TransactionID: prepare.TransactionID, FromID: pID, ToID: coordID}
+	if msg := <-pChan; msg.Type != protocol.MsgCommit {
+		t.Fatalf("Expected Commit, got %s", msg.Type)
+	}
+
+	// Pretend the Commit was lost and ask for it
+	coord.Inbox <- protocol.Message{Type: protocol.MsgDecisionRequest, TransactionID: prepare.TransactionID, FromID: pID, ToID: coordID}
+	if reply := <-pChan; reply.Type != protocol.MsgDecisionReply || reply.Decision != protocol.MsgCommit {
+		t.Errorf("Expected DecisionReply(Commit), got %s(%s)", reply.Type, reply.Decision)
+	}
+	coord.Inbox <- protocol.Message{Type: protocol.MsgAck, TransactionID: prepare.TransactionID, FromID: pID, ToID: coordID}
+	<-done
+
+	// A transaction the coordinator never heard of is presumed aborted
+	unknown := uuid.New()
+	coord.Inbox <- protocol.Message{Type: protocol.MsgDecisionRequest, TransactionID: unknown, FromID: pID, ToID: coordID}
+	select {
+	case reply := <-pChan:
+		if reply.TransactionID != unknown || reply.Decision != protocol.MsgAbort {
+			t.Errorf("Expected presumed Abort for unknown Tx, got %s for %s", reply.Decision, reply.TransactionID)
+		}
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("Timeout waiting for DecisionReply")
+	}
+}
+
+func TestCoordinatorPresumedCommit(t *testing.T) {
+	net := NewMockNetwork()
+	pID := "p1"
+	pChan := make(chan protocol.Message, 10)
+	net.Register(pID, pChan)
+
+	coordID := "coord"
+	coord := NewCoordinator(coordID, net, []string{pID}, 1*time.Second, 50*time.Millisecond)
+	coord.Mode = ModePresumedCommit
+	l := wal.NewMemoryLog(0)
+	coord.Log = l
+	coord.Start()
+
+	done := make(chan bool)
+	go func() {
+		committed, _ := coord.RunTransaction()
+		done <- committed
+	}()
+
+	prepare := <-pChan
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteYes, TransactionID: prepare.TransactionID, FromID: pID, ToID: coordID}
+
+	// Commits are not acknowledged, so RunTransaction must finish without an Ack
+	select {
+	case committed := <-done:
+		if !committed {
+			t.Error("Transaction aborted, expected Commit")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Coordinator waited for an Ack under presumed commit")
+	}
+
+	// Collecting record and commit record are both forced
+	if got := l.Stats().Forces; got != 2 {
+		t.Errorf("Expected 2 forced writes, got %d", got)
+	}
+
+	// Transactions the coordinator has no record of are presumed committed
+	coord.Inbox <- protocol.Message{Type: protocol.MsgDecisionRequest, TransactionID: uuid.New(), FromID: pID, ToID: coordID}
+	for msg := range pChan {
+		if msg.Type == protocol.MsgDecisionReply {
+			if msg.Decision != protocol.MsgCommit {
+				t.Errorf("Expected presumed Commit, got %s", msg.Decision)
+			}
+			break
+		}
+	}
+}
+
+func TestCoordinatorReadOnlySkipsPhase2(t *testing.T) {
+	net := NewMockNetwork()
+	writer, reader := "p1", "p2"
+	writerChan := make(chan protocol.Message, 10)
+	readerChan := make(chan protocol.Message, 10)
+	net.Register(writer, writerChan)
+	net.Register(reader, readerChan)
+
+	coordID := "coord"
+	coord := NewCoordinator(coordID, net, []string{writer, reader}, 1*time.Second, 50*time.Millisecond)
+	coord.Clock = clock.NewFake(time.Time{})
+	coord.Start()
+
+	done := make(chan bool)
+	go func() {
+		committed, _ := coord.RunTransaction()
+		done <- committed
+	}()
+
+	prepare := <-writerChan
+	<-readerChan
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteReadOnly, TransactionID: prepare.TransactionID, FromID: reader, ToID: coordID}
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteYes, TransactionID: prepare.TransactionID, FromID: writer, ToID: coordID}
+
+	if msg := <-writerChan; msg.Type != protocol.MsgCommit {
+		t.Fatalf("Expected Commit, got %s", msg.Type)
+	}
+	coord.Inbox <- protocol.Message{Type: protocol.MsgAck, TransactionID: prepare.TransactionID, FromID: writer, ToID: coordID}
+
+	if !<-done {
+		t.Error("Transaction aborted, expected Commit")
+	}
+	for len(readerChan) > 0 {
+		if msg := <-readerChan; msg.Type == protocol.MsgCommit {
+			t.Error("Read-only participant should not receive the decision")
+		}
+	}
+}
+
+func TestCoordinatorConcurrentTransactions(t *testing.T) {
+	net := NewMockNetwork()
+	pID := "p1"
+	pChan := make(chan protocol.Message, 10)
+	net.Register(pID, pChan)
+
+	coordID := "coord"
+	coord := NewCoordinator(coordID, net, []string{pID}, 1*time.Second, 500*time.Millisecond)
 	coord.Start()
 
-	// RunTransaction will timeout because we never reply
-	go coord.RunTransaction()
+	first := coord.Begin()
+	second := coord.Begin()
+	<-pChan
+	<-pChan
 
-	// Allow some time for retries
-	time.Sleep(150 * time.Millisecond)
+	// Votes arrive out of order; each must reach its own transaction
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteNo, TransactionID: second.ID, FromID: pID, ToID: coordID}
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteYes, TransactionID: first.ID, FromID: pID, ToID: coordID}
 
-	net.mu.Lock()
-	count := 0
-	for _, msg := range net.SentMessages {
-		if msg.Type == protocol.MsgPrepare {
-			count++
+	decisions := make(map[interface{}]protocol.MessageType)
+	for len(decisions) < 2 {
+		select {
+		case msg := <-pChan:
+			decisions[msg.TransactionID] = msg.Type
+			coord.Inbox <- protocol.Message{Type: protocol.MsgAck, TransactionID: msg.TransactionID, FromID: pID, ToID: coordID}
+		case <-time.After(200 * time.Millisecond):
+			t.Fatal("Timeout waiting for decisions")
 		}
 	}
-	net.mu.Unlock()
+	if decisions[first.ID] != protocol.MsgCommit || decisions[second.ID] != protocol.MsgAbort {
+		t.Errorf("Expected Commit for the first Tx and Abort for the second, got %v", decisions)
+	}
+
+	if committed, _ := first.Wait(); !committed {
+		t.Error("First transaction aborted, expected Commit")
+	}
+	if committed, _ := second.Wait(); committed {
+		t.Error("Second transaction committed, expected Abort")
+	}
+}
+
+func TestCoordinatorRoutesOperations(t *testing.T) {
+	net := NewMockNetwork()
+	p1Chan := make(chan protocol.Message, 10)
+	p2Chan := make(chan protocol.Message, 10)
+	p3Chan := make(chan protocol.Message, 10)
+	net.Register("p1", p1Chan)
+	net.Register("p2", p2Chan)
+	net.Register("p3", p3Chan)
+
+	coordID := "coord"
+	coord := NewCoordinator(coordID, net, []string{"p1", "p2", "p3"}, 1*time.Second, 500*time.Millisecond)
+	coord.Owner = func(key string) string { return "p" + key[:1] }
+	coord.Start()
+
+	tx := coord.BeginOps([]protocol.Operation{
+		{Type: protocol.OpPut, Key: "1a", Value: "x"},
+		{Type: protocol.OpGet, Key: "2b"},
+		{Type: protocol.OpPut, Key: "1c", Value: "y"},
+	})
+
+	prepare1, prepare2 := <-p1Chan, <-p2Chan
+	if len(prepare1.Operations) != 2 || prepare1.Operations[0].Key != "1a" || prepare1.Operations[1].Key != "1c" {
+		t.Errorf("Expected p1 to get both of its writes in order, got %+v", prepare1.Operations)
+	}
+	if len(prepare2.Operations) != 1 || prepare2.Operations[0].Key != "2b" {
+		t.Errorf("Expected p2 to get its read, got %+v", prepare2.Operations)
+	}
+	if len(prepare1.Participants) != 2 || len(tx.Participants) != 2 {
+		t.Errorf("Only the owners should take part, got %v", prepare1.Participants)
+	}
+	select {
+	case msg := <-p3Chan:
+		t.Errorf("p3 owns no keys but received %s", msg.Type)
+	default:
+	}
+
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteReadOnly, TransactionID: tx.ID, FromID: "p2", ToID: coordID,
+		Operations: []protocol.Operation{{Type: protocol.OpGet, Key: "2b", Value: "old", Found: true}}}
+	coord.Inbox <- protocol.Message{Type: protocol.MsgVoteYes, TransactionID: tx.ID, FromID: "p1", ToID: coordID}
+	if msg := <-p1Chan; msg.Type != protocol.MsgCommit {
+		t.Fatalf("Expected Commit, got %s", msg.Type)
+	}
+	coord.Inbox <- protocol.Message{Type: protocol.MsgAck, TransactionID: tx.ID, FromID: "p1", ToID: coordID}
+
+	if committed, _ := tx.Wait(); !committed {
+		t.Fatal("Transaction aborted, expected Commit")
+	}
+	if v, ok := tx.Read("2b"); !ok || v != "old" {
+		t.Errorf("Expected the read of 2b to come back, got %q", v)
+	}
+}
+
+func TestCoordinatorRejectsUnroutableOperations(t *testing.T) {
+	net := NewMockNetwork()
+	coord := NewCoordinator("coord", net, []string{"p1", "p2"}, 1*time.Second, 500*time.Millisecond)
+	coord.Owner = func(key string) string { return "p" + key[:1] }
+	coord.Start()
+
+	// p3 owns "3c" but is not one of ours; its write must not be dropped
+	tx := coord.BeginOps([]protocol.Operation{
+		{Type: protocol.OpPut, Key: "1a", Value: "x"},
+		{Type: protocol.OpPut, Key: "3c", Value: "y"},
+	})
+	if committed, _ := tx.Wait(); committed {
+		t.Error("A transaction with an unroutable operation should abort")
+	}
+
+	// Nor may an empty participant list divide by zero when hashing keys
+	empty := NewCoordinator("coord", net, nil, 1*time.Second, 500*time.Millisecond)
+	empty.Start()
+	if committed, _ := empty.BeginOps([]protocol.Operation{{Type: protocol.OpPut, Key: "a"}}).Wait(); committed {
+		t.Error("A coordinator without participants should not commit")
+	}
+	if len(net.SentMessages) != 0 {
+		t.Errorf("Nothing should have been sent, got %v", net.SentMessages)
+	}
+}
+
+func TestCoordinatorRoutesByRangeTable(t *testing.T) {
+	net := NewMockNetwork()
+	p1Chan := make(chan protocol.Message, 10)
+	p2Chan := make(chan protocol.Message, 10)
+	net.Register("p1", p1Chan)
+	net.Register("p2", p2Chan)
+
+	coord := NewCoordinator("coord", net, []string{"p1", "p2"}, 1*time.Second, 500*time.Millisecond)
+	table, err := partition.NewTable([]partition.Range{{Start: "", Owner: "p1"}, {Start: "m", Owner: "p2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	coord.Owner = table.Owner
+	coord.Start()
+
+	// Both keys fall in p2's range, so this is a single-partition transaction
+	tx := coord.BeginOps([]protocol.Operation{
+		{Type: protocol.OpPut, Key: "melon", Value: "x"},
+		{Type: protocol.OpPut, Key: "peach", Value: "y"},
+	})
+	if len(tx.Participants) != 1 || tx.Participants[0] != "p2" {
+		t.Fatalf("Expected only p2 to take part, got %v", tx.Participants)
+	}
+	if msg := <-p2Chan; len(msg.Operations) != 2 {
+		t.Errorf("Expected p2 to get both writes, got %+v", msg.Operations)
+	}
+	select {
+	case msg := <-p1Chan:
+		t.Errorf("p1 owns neither key but received %s", msg.Type)
+	default:
+	}
+}
+
+func TestCoordinatorOnePhase(t *testing.T) {
+	net := NewMockNetwork()
+	p1Chan := make(chan protocol.Message, 10)
+	p2Chan := make(chan protocol.Message, 10)
+	net.Register("p1", p1Chan)
+	net.Register("p2", p2Chan)
+
+	coordID := "coord"
+	coord := NewCoordinator(coordID, net, []string{"p1", "p2"}, 1*time.Second, 500*time.Millisecond)
+	coord.Owner = func(key string) string { return "p1" }
+	coord.OnePhase = true
+	coord.Start()
+
+	tx := coord.BeginOps([]protocol.Operation{{Type: protocol.OpGet, Key: "a"}})
+	msg := <-p1Chan
+	if msg.Type != protocol.MsgCommitOnePhase || len(msg.Operations) != 1 {
+		t.Fatalf("Expected CommitOnePhase with the operation, got %s %+v", msg.Type, msg.Operations)
+	}
+	coord.Inbox <- protocol.Message{Type: protocol.MsgOnePhaseReply, TransactionID: tx.ID, FromID: "p1", ToID: coordID,
+		Decision: protocol.MsgCommit, Operations: []protocol.Operation{{Type: protocol.OpGet, Key: "a", Value: "1", Found: true}}}
+
+	if committed, _ := tx.Wait(); !committed || !tx.OnePhase {
+		t.Fatalf("Expected a one-phase Commit, got committed=%v onePhase=%v", committed, tx.OnePhase)
+	}
+	if v, _ := tx.Read("a"); v != "1" {
+		t.Errorf("Expected the read to come back, got %q", v)
+	}
+	if records, _ := coord.Log.Records(); len(records) != 0 {
+		t.Errorf("One-phase commit should leave nothing in the coordinator's log, got %v", records)
+	}
+
+	// A transaction over both participants still runs 2PC
+	coord.Owner = nil
+	tx = coord.Begin()
+	if msg := <-p1Chan; msg.Type != protocol.MsgPrepare {
+		t.Errorf("Expected Prepare for a multi-participant transaction, got %s", msg.Type)
+	}
+	if tx.OnePhase {
+		t.Error("A multi-participant transaction is not one-phase")
+	}
+}
 
-	if count < 2 {
-		t.Errorf("Expected multiple Prepare messages (retries), got %d", count)
+func TestCoordinatorOnePhaseTimeout(t *testing.T) {
+	sched := sim.NewScheduler(1)
+	net := transport.NewSimulatedNetwork(time.Millisecond, 0, 0)
+	net.Clock = sched
+
+	p := NewParticipant("p1", net, "coord")
+	p.Clock = sched
+	p.Locks.Clock = sched
+	p.Locks.Policy = lock.WaitTimeout
+	p.LockTimeout = 0
+	p.Retention = 0
+	p.Start()
+	coord := NewCoordinator("coord", net, []string{"p1"}, 100*time.Millisecond, 30*time.Millisecond)
+	coord.Clock = sched
+	coord.OnePhase = true
+	coord.Start()
+
+	// The key stays locked past the coordinator's timeout
+	blocker := uuid.New()
+	p.Locks.Acquire(blocker, 0, "a", lock.Exclusive)
+	sched.AfterFunc(150*time.Millisecond, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.Locks.ReleaseAll(blocker)
+	})
+	tx := coord.BeginOps([]protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "x"}})
+	sched.Run()
+
+	// The participant was told to abort, so it never ran the write
+	if committed, d := tx.Wait(); committed || d < 100*time.Millisecond {
+		t.Errorf("Expected an abort after the timeout, got committed=%v after %v", committed, d)
+	}
+	if state := p.State(tx.ID); state != protocol.StateAborted {
+		t.Errorf("Expected the participant to abort, got %s", state)
+	}
+	if v, ok := p.Store.Get("a"); ok {
+		t.Errorf("The aborted write should not be in the store, got %q", v)
+	}
+
+	// One that had committed, but whose reply was lost, says so
+	net.Block("p1", "coord")
+	tx = coord.BeginOps([]protocol.Operation{{Type: protocol.OpPut, Key: "b", Value: "y"}})
+	sched.AfterFunc(150*time.Millisecond, func() { net.Heal("p1", "coord") })
+	sched.Run()
+	if committed, d := tx.Wait(); !committed || d < 150*time.Millisecond {
+		t.Errorf("Expected the commit to be reported once the participant answered, got committed=%v after %v", committed, d)
+	}
+	if v, _ := p.Store.Get("b"); v != "y" {
+		t.Errorf("Expected the committed write in the store, got %q", v)
 	}
 }
@@ -0,0 +1,38 @@
+package node
+
+import "fmt"
+
+// CrashPoint identifies where in the protocol a scheduled crash fires
+type CrashPoint int
+
+const (
+	CrashNone     CrashPoint = iota
+	CrashPrepare             // Prepare received, vote not yet sent
+	CrashReady               // Voted Yes, waiting for the decision
+	CrashDecision            // Decision received, not yet logged
+)
+
+func (c CrashPoint) String() string {
+	switch c {
+	case CrashNone:
+		return "none"
+	case CrashPrepare:
+		return "prepare"
+	case CrashReady:
+		return "ready"
+	case CrashDecision:
+		return "decision"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseCrashPoint converts a CLI phase name into a CrashPoint
+func ParseCrashPoint(s string) (CrashPoint, error) {
+	for _, c := range []CrashPoint{CrashNone, CrashPrepare, CrashReady, CrashDecision} {
+		if c.String() == s {
+			return c, nil
+		}
+	}
+	return CrashNone, fmt.Errorf("unknown crash phase %q (want none, prepare, ready or decision)", s)
+}
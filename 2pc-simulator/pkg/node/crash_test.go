@@ -0,0 +1,16 @@
+package node
+
+import "testing"
+
+func TestParseCrashPoint(t *testing.T) {
+	for _, c := range []CrashPoint{CrashNone, CrashPrepare, CrashReady, CrashDecision} {
+		got, err := ParseCrashPoint(c.String())
+		if err != nil || got != c {
+			t.Errorf("ParseCrashPoint(%q) = %v, %v; want %v", c.String(), got, err, c)
+		}
+	}
+
+	if _, err := ParseCrashPoint("sometime"); err == nil {
+		t.Error("Expected an error for an unknown phase")
+	}
+}
@@ -0,0 +1,72 @@
+package node
+
+import (
+	"fmt"
+
+	"2pc-sim/pkg/protocol"
+)
+
+// CommitMode selects one of the classic 2PC logging and acknowledgement variants
+type CommitMode int
+
+const (
+	// ModeStandard forces every decision and acknowledges both outcomes
+	ModeStandard CommitMode = iota
+	// ModePresumedAbort drops the Ack and the forced record for aborts; a
+	// coordinator with no record of a transaction answers Abort
+	ModePresumedAbort
+	// ModePresumedCommit drops the Ack and the participants' forced record for
+	// commits, at the price of a forced "collecting" record before Prepare; a
+	// coordinator with no record of a transaction answers Commit
+	ModePresumedCommit
+)
+
+func (m CommitMode) String() string {
+	switch m {
+	case ModeStandard:
+		return "standard"
+	case ModePresumedAbort:
+		return "presumed-abort"
+	case ModePresumedCommit:
+		return "presumed-commit"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseCommitMode converts a CLI mode name into a CommitMode
+func ParseCommitMode(s string) (CommitMode, error) {
+	for _, m := range []CommitMode{ModeStandard, ModePresumedAbort, ModePresumedCommit} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return ModeStandard, fmt.Errorf("unknown commit mode %q (want standard, presumed-abort or presumed-commit)", s)
+}
+
+// acked reports whether participants acknowledge the given decision
+func (m CommitMode) acked(decision protocol.MessageType) bool {
+	switch m {
+	case ModePresumedAbort:
+		return decision != protocol.MsgAbort
+	case ModePresumedCommit:
+		return decision != protocol.MsgCommit
+	default:
+		return true
+	}
+}
+
+// coordinatorForces reports whether the coordinator must force its record of
+// the decision. Under presumed abort an abort needs no record at all, so it is
+// written lazily; a participant forces exactly the decisions it acknowledges.
+func (m CommitMode) coordinatorForces(decision protocol.MessageType) bool {
+	return m != ModePresumedAbort || decision != protocol.MsgAbort
+}
+
+// presumed is the answer to an inquiry about a transaction with no record
+func (m CommitMode) presumed() protocol.MessageType {
+	if m == ModePresumedCommit {
+		return protocol.MsgCommit
+	}
+	return protocol.MsgAbort
+}
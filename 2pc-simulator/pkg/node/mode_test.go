@@ -0,0 +1,51 @@
+package node
+
+import (
+	"testing"
+
+	"2pc-sim/pkg/protocol"
+)
+
+func TestParseCommitMode(t *testing.T) {
+	for _, m := range []CommitMode{ModeStandard, ModePresumedAbort, ModePresumedCommit} {
+		got, err := ParseCommitMode(m.String())
+		if err != nil || got != m {
+			t.Errorf("ParseCommitMode(%q) = %v, %v; want %v", m.String(), got, err, m)
+		}
+	}
+
+	if _, err := ParseCommitMode("presumed-nothing-at-all"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestCommitModeRules(t *testing.T) {
+	tests := []struct {
+		mode                   CommitMode
+		ackCommit, ackAbort    bool
+		forceCommit, forceAbrt bool
+		presumed               protocol.MessageType
+	}{
+		{ModeStandard, true, true, true, true, protocol.MsgAbort},
+		{ModePresumedAbort, true, false, true, false, protocol.MsgAbort},
+		{ModePresumedCommit, false, true, true, true, protocol.MsgCommit},
+	}
+
+	for _, tc := range tests {
+		if got := tc.mode.acked(protocol.MsgCommit); got != tc.ackCommit {
+			t.Errorf("%s: acked(Commit) = %v", tc.mode, got)
+		}
+		if got := tc.mode.acked(protocol.MsgAbort); got != tc.ackAbort {
+			t.Errorf("%s: acked(Abort) = %v", tc.mode, got)
+		}
+		if got := tc.mode.coordinatorForces(protocol.MsgCommit); got != tc.forceCommit {
+			t.Errorf("%s: coordinatorForces(Commit) = %v", tc.mode, got)
+		}
+		if got := tc.mode.coordinatorForces(protocol.MsgAbort); got != tc.forceAbrt {
+			t.Errorf("%s: coordinatorForces(Abort) = %v", tc.mode, got)
+		}
+		if got := tc.mode.presumed(); got != tc.presumed {
+			t.Errorf("%s: presumed() = %s", tc.mode, got)
+		}
+	}
+}
@@ -2,142 +2,937 @@ package node
 
 import (
 	"log"
+	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/lock"
 	"2pc-sim/pkg/protocol"
+	"2pc-sim/pkg/storage"
 	"2pc-sim/pkg/transport"
+	"2pc-sim/pkg/wal"
 )
 
+// DefaultInquiryInterval is how long a participant stays Ready before asking for the decision
+const DefaultInquiryInterval = time.Second
+
+// DefaultLockTimeout is how long a transaction waits for a lock under the
+// timeout policy before it gives up
+const DefaultLockTimeout = 100 * time.Millisecond
+
+// DefaultRetention is how long a finished transaction is remembered, so that
+// retransmitted messages still get the answer they got the first time
+const DefaultRetention = time.Minute
+
 // Participant represents a node in the distributed system
 type Participant struct {
 	ID            string
-	State         protocol.State
 	Net           transport.Network
 	Inbox         chan protocol.Message
 	CoordinatorID string
-	mu            sync.Mutex
+	Log           wal.Log
+	Mode          CommitMode
+	// Store holds the node's data; a transaction's writes are buffered in
+	// its write set until the decision arrives
+	Store *storage.Store
+	// Locks implements strict two-phase locking: a transaction's locks are
+	// taken as its operations run and held until Commit or Abort. Its Policy
+	// decides whether a transaction waits for a lock held by another.
+	Locks *lock.Manager
+	mu    sync.Mutex
+	txns  map[uuid.UUID]*participantTxn
+	last  uuid.UUID // most recent transaction, for CurrentState
+	stop  chan struct{}
+	// Transactions that have left Ready, and their total time there
+	blockedTxns  int
+	blockedTotal time.Duration
+	crashed      bool
+	// InquiryInterval is how long a Ready participant waits before asking
+	// the coordinator for the decision (and between repeated asks)
+	InquiryInterval time.Duration
+	// Cooperative lets a blocked participant ask its peers once the
+	// coordinator has failed to answer an inquiry
+	Cooperative bool
+	// Retention is how long a committed or aborted transaction is kept in memory
+	Retention time.Duration
+	// LockTimeout is how long a transaction waits for a lock under the timeout policy
+	LockTimeout time.Duration
 	// Logic hooks for simulation
 	ForceVoteNo bool
-	// Metrics
-	ReadyTime time.Time
+	// VoteNoRate is the chance of voting No, drawn afresh for every
+	// transaction from a generator that Seed makes repeatable
+	VoteNoRate float64
+	rng        *rand.Rand
+	// ReadOnly makes a Prepare without operations vote ReadOnly and skip
+	// Phase 2. With operations, only whether they write counts.
+	ReadOnly bool
+	// CrashPoint schedules a single crash; the node recovers after CrashDowntime
+	CrashPoint    CrashPoint
+	CrashDowntime time.Duration
+	// Clock drives the participant's timers and its blocking measurements
+	Clock clock.Clock
+	// OnBlocked, if set, is told how long each transaction that leaves Ready
+	// spent there, blocked on the decision. It runs with the participant's
+	// mutex held, so it must not call back into the participant.
+	OnBlocked func(txID uuid.UUID, d time.Duration)
+}
+
+// participantTxn is the participant's view of a single transaction
+type participantTxn struct {
+	state     protocol.State
+	peers     []string // other participants in the transaction
+	inquiries int      // unanswered inquiries since entering Ready
+	inquiry   clock.Timer
+	forget    clock.Timer
+	readOnly  bool                 // voted ReadOnly, so the outcome is none of our business
+	results   []protocol.Operation // what our Gets read, resent with a repeated vote
+	// While the operations run, possibly waiting for locks
+	prepare   protocol.Message
+	next      int  // index of the next operation to run
+	executing bool // has not voted yet
+	shared    bool // only reads, so it will vote ReadOnly
+	voteNo    bool // the simulation made it vote No
+	onePhase  bool // ours alone to decide, see handleCommitOnePhase
+	lockWait  clock.Timer
+	readyTime time.Time // when it entered Ready, to measure blocking
 }
 
 func NewParticipant(id string, net transport.Network, coordinatorID string) *Participant {
-	return &Participant{
-		ID:            id,
-		State:         protocol.StateInit,
-		Net:           net,
-		Inbox:         make(chan protocol.Message, 100),
-		CoordinatorID: coordinatorID,
+	p := &Participant{
+		ID:              id,
+		Net:             net,
+		Inbox:           make(chan protocol.Message, 100),
+		CoordinatorID:   coordinatorID,
+		Log:             wal.NewMemoryLog(0),
+		Store:           storage.NewStore(),
+		Locks:           lock.NewManager(),
+		txns:            make(map[uuid.UUID]*participantTxn),
+		InquiryInterval: DefaultInquiryInterval,
+		Retention:       DefaultRetention,
+		LockTimeout:     DefaultLockTimeout,
+		Clock:           clock.Real{},
+		rng:             rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
+	p.Locks.OnGrant = p.resume
+	p.Locks.OnVictim = p.onVictim
+	return p
 }
 
 func (p *Participant) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.start()
+}
+
+func (p *Participant) start() {
+	p.stop = make(chan struct{})
 	p.Net.Register(p.ID, p.Inbox)
-	go p.loop()
+	stop := p.stop
+	serve(p.Clock, stop, p.Inbox, func(msg protocol.Message) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		// A message picked up just as we crashed belongs to the dead incarnation
+		if p.stop == stop && !p.crashed {
+			p.handleMessage(msg)
+		}
+	})
 }
 
-func (p *Participant) loop() {
-	for msg := range p.Inbox {
-		p.handleMessage(msg)
+// State returns the participant's state for a transaction; transactions it
+// never heard of, or has forgotten, are in Init
+func (p *Participant) State(txID uuid.UUID) protocol.State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if tx, ok := p.txns[txID]; ok {
+		return tx.state
 	}
+	return protocol.StateInit
 }
 
-func (p *Participant) handleMessage(msg protocol.Message) {
+// CurrentState returns the state of the participant's latest transaction
+func (p *Participant) CurrentState() protocol.State {
+	p.mu.Lock()
+	last := p.last
+	p.mu.Unlock()
+	return p.State(last)
+}
+
+// BlockingTime returns how many transactions have left Ready and how long
+// they spent there in total, blocked on the decision. A transaction in doubt
+// across a crash is counted from the restart.
+func (p *Participant) BlockingTime() (int, time.Duration) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	return p.blockedTxns, p.blockedTotal
+}
+
+// StillBlocked returns how long each transaction still in Ready has waited
+// there so far. Its window has not closed, so BlockingTime and OnBlocked do
+// not count it yet.
+func (p *Participant) StillBlocked() map[uuid.UUID]time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	open := make(map[uuid.UUID]time.Duration)
+	for txID, tx := range p.txns {
+		if tx.state == protocol.StateReady {
+			open[txID] = p.Clock.Since(tx.readyTime)
+		}
+	}
+	return open
+}
+
+// txn returns the participant's view of a transaction, starting it in Init
+// if it is new
+func (p *Participant) txn(txID uuid.UUID) *participantTxn {
+	tx, ok := p.txns[txID]
+	if !ok {
+		tx = &participantTxn{state: protocol.StateInit}
+		p.txns[txID] = tx
+		p.last = txID
+	}
+	return tx
+}
 
+// Crash stops the participant and throws away everything that is not in its log
+func (p *Participant) Crash() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.crash()
+}
+
+func (p *Participant) crash() {
+	if p.crashed {
+		return
+	}
+	log.Printf("[Participant %s] CRASHED", p.ID)
+	p.crashed = true
+	p.Net.Unregister(p.ID)
+	if p.stop != nil {
+		close(p.stop)
+	}
+	for _, tx := range p.txns {
+		stopTimer(&tx.inquiry)
+		stopTimer(&tx.forget)
+		stopTimer(&tx.lockWait)
+	}
+	p.txns = make(map[uuid.UUID]*participantTxn)
+	p.last = uuid.Nil
+	p.Store.Reset()
+	p.Locks.Reset()
+}
+
+// Recover restarts a crashed participant and rebuilds its state from the log.
+// Committed write sets are redone in log order. A transaction that never
+// reached Prepared is aborted unilaterally; one that is in doubt (Prepared
+// without a decision) gets its write set back and asks the coordinator for
+// the outcome.
+func (p *Participant) Recover() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.crashed {
+		return
+	}
+
+	records, err := p.Log.Records()
+	if err != nil {
+		log.Printf("[Participant %s] Failed to read log, staying down: %v", p.ID, err)
+		return
+	}
+
+	// Messages queued while we were down were lost with the process
+	for len(p.Inbox) > 0 {
+		<-p.Inbox
+	}
+
+	var order []uuid.UUID
+	for _, rec := range records {
+		if _, ok := p.txns[rec.TransactionID]; !ok {
+			order = append(order, rec.TransactionID)
+		}
+		tx := p.txn(rec.TransactionID)
+		if rec.Coordinator != "" {
+			p.CoordinatorID = rec.Coordinator
+		}
+		switch rec.Type {
+		case wal.RecBegin:
+			tx.state = protocol.StateInit
+		case wal.RecPrepared:
+			tx.state = protocol.StateReady
+			tx.peers = rec.Participants
+			p.Store.Stage(rec.TransactionID, rec.Writes)
+		case wal.RecCommit:
+			tx.state = protocol.StateCommitted
+			if rec.Writes != nil {
+				// Committed in one phase, without a Prepared record
+				p.Store.Stage(rec.TransactionID, rec.Writes)
+			}
+			p.Store.Commit(rec.TransactionID)
+		case wal.RecAbort:
+			tx.state = protocol.StateAborted
+			p.Store.Abort(rec.TransactionID)
+		case wal.RecReadOnly:
+			tx.readOnly = true
+		}
+	}
+
+	p.crashed = false
+	p.start()
+	log.Printf("[Participant %s] RECOVERED with %d logged transactions", p.ID, len(order))
+
+	for _, txID := range order {
+		tx := p.txns[txID]
+		switch {
+		case tx.readOnly:
+			// Voted without a stake in the outcome
+			p.setOutcome(tx, txID, protocol.StateInit)
+		case tx.state == protocol.StateInit:
+			if p.force(wal.RecAbort, txID) {
+				p.setOutcome(tx, txID, protocol.StateAborted)
+				log.Printf("[Participant %s] Unilaterally ABORTED Tx %s", p.ID, txID)
+			}
+		case tx.state == protocol.StateReady:
+			// In doubt: nobody may touch what we may yet commit. Nobody
+			// holds a lock yet, so these are granted at once.
+			for _, w := range p.Store.Writes(txID) {
+				p.Locks.Acquire(txID, 0, w.Key, lock.Exclusive)
+			}
+			// Ask straight away rather than waiting for the first timer
+			tx.readyTime = p.Clock.Now()
+			tx.inquiries = 0
+			p.sendInquiry(tx, txID)
+			p.scheduleInquiry(tx, txID)
+		default:
+			p.setOutcome(tx, txID, tx.state)
+		}
+	}
+}
+
+// setOutcome moves a transaction to Committed or Aborted (or leaves a
+// read-only one in Init), applies or discards its write set, releases its
+// locks and schedules it to be forgotten
+func (p *Participant) setOutcome(tx *participantTxn, txID uuid.UUID, state protocol.State) {
+	if tx.state == protocol.StateReady {
+		// The blocking window is over
+		d := p.Clock.Since(tx.readyTime)
+		p.blockedTxns++
+		p.blockedTotal += d
+		if p.OnBlocked != nil {
+			p.OnBlocked(txID, d)
+		}
+	}
+	tx.state = state
+	tx.executing = false
+	stopTimer(&tx.lockWait)
+	if state == protocol.StateCommitted {
+		p.Store.Commit(txID)
+	} else {
+		p.Store.Abort(txID)
+	}
+	p.Locks.ReleaseAll(txID)
+	stopTimer(&tx.inquiry)
+	stopTimer(&tx.forget)
+	if p.Retention > 0 {
+		tx.forget = p.Clock.AfterFunc(p.Retention, func() { p.forget(tx, txID) })
+	}
+}
+
+// forget drops a finished transaction from memory; its outcome stays in the log
+func (p *Participant) forget(tx *participantTxn, txID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.txns[txID] != tx {
+		return
+	}
+	delete(p.txns, txID)
+}
+
+// scheduleInquiry arms the timer that asks the coordinator for the decision
+// while we are blocked in Ready
+func (p *Participant) scheduleInquiry(tx *participantTxn, txID uuid.UUID) {
+	stopTimer(&tx.inquiry)
+	if p.InquiryInterval <= 0 {
+		return
+	}
+	tx.inquiry = p.Clock.AfterFunc(p.InquiryInterval, func() { p.inquire(tx, txID) })
+}
+
+func stopTimer(t *clock.Timer) {
+	if *t != nil {
+		(*t).Stop()
+		*t = nil
+	}
+}
+
+func (p *Participant) inquire(tx *participantTxn, txID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.crashed || p.txns[txID] != tx || tx.state != protocol.StateReady {
+		return
+	}
+	p.sendInquiry(tx, txID)
+	p.scheduleInquiry(tx, txID)
+}
+
+// askPeers runs the cooperative termination protocol: any peer that has
+// committed, aborted or never voted can tell us the outcome
+func (p *Participant) askPeers(tx *participantTxn, txID uuid.UUID) {
+	for _, peer := range tx.peers {
+		p.Net.Send(protocol.Message{
+			Type:          protocol.MsgPeerDecisionRequest,
+			TransactionID: txID,
+			FromID:        p.ID,
+			ToID:          peer,
+		})
+	}
+}
+
+func (p *Participant) sendInquiry(tx *participantTxn, txID uuid.UUID) {
+	if p.Cooperative && tx.inquiries > 0 {
+		log.Printf("[Participant %s] Coordinator silent, asking peers about Tx %s", p.ID, txID)
+		p.askPeers(tx, txID)
+	}
+	tx.inquiries++
+	log.Printf("[Participant %s] Asking %s for the decision on Tx %s", p.ID, p.CoordinatorID, txID)
+	p.Net.Send(protocol.Message{
+		Type:          protocol.MsgDecisionRequest,
+		TransactionID: txID,
+		FromID:        p.ID,
+		ToID:          p.CoordinatorID,
+	})
+}
+
+// crashAt fires the scheduled crash if it is set for point
+func (p *Participant) crashAt(point CrashPoint) bool {
+	if p.CrashPoint != point {
+		return false
+	}
+	p.CrashPoint = CrashNone
+	p.crash()
+	if p.CrashDowntime > 0 {
+		p.Clock.AfterFunc(p.CrashDowntime, p.Recover)
+	}
+	return true
+}
+
+// handleMessage dispatches msg; the caller must hold p.mu
+func (p *Participant) handleMessage(msg protocol.Message) {
 	log.Printf("[Participant %s] Rx %s from %s", p.ID, msg.Type, msg.FromID)
 
 	switch msg.Type {
 	case protocol.MsgPrepare:
 		p.handlePrepare(msg)
+	case protocol.MsgCommitOnePhase:
+		p.handleCommitOnePhase(msg)
 	case protocol.MsgCommit:
 		p.handleCommit(msg)
 	case protocol.MsgAbort:
 		p.handleAbort(msg)
+	case protocol.MsgDecisionReply:
+		p.handleDecisionReply(msg)
+	case protocol.MsgPeerDecisionRequest:
+		p.handlePeerDecisionRequest(msg)
+	case protocol.MsgPeerDecisionReply:
+		p.handlePeerDecisionReply(msg)
 	default:
 		log.Printf("[Participant %s] Ignoring unexpected message type %s", p.ID, msg.Type)
 	}
 }
 
 func (p *Participant) handlePrepare(msg protocol.Message) {
-	// Idempotency: If we already voted, resend that vote
-	if p.State == protocol.StateReady {
-		p.Net.Send(protocol.Message{
-			Type:          protocol.MsgVoteYes,
-			TransactionID: msg.TransactionID,
-			FromID:        p.ID,
-			ToID:          msg.FromID,
-		})
+	tx, known := p.txns[msg.TransactionID]
+	if known {
+		// Idempotency: If we already voted, resend that vote
+		switch {
+		case tx.readOnly:
+			p.vote(protocol.MsgVoteReadOnly, msg, tx.results)
+		case tx.state == protocol.StateReady:
+			p.vote(protocol.MsgVoteYes, msg, tx.results)
+		case tx.state == protocol.StateAborted:
+			p.vote(protocol.MsgVoteNo, msg, nil)
+		}
+		// If Committed, we ignore Prepare (we must have voted Yes already).
+		// A transaction still in Init is waiting for a lock and votes once it
+		// has run, or is one we crashed in and will abort.
 		return
 	}
-	if p.State == protocol.StateAborted {
-		p.Net.Send(protocol.Message{
-			Type:          protocol.MsgVoteNo,
-			TransactionID: msg.TransactionID,
-			FromID:        p.ID,
-			ToID:          msg.FromID,
-		})
+
+	tx = p.txn(msg.TransactionID)
+	for _, id := range msg.Participants {
+		if id != p.ID {
+			tx.peers = append(tx.peers, id)
+		}
+	}
+	tx.prepare = msg
+	tx.voteNo = p.drawVoteNo()
+	tx.shared = p.readOnly(msg.Operations) && !tx.voteNo
+
+	if !tx.shared {
+		// Remember that work started, so a crash before voting aborts on recovery
+		p.writeLog(wal.RecBegin, msg.TransactionID, false)
+		if p.crashAt(CrashPrepare) {
+			return
+		}
+	}
+
+	tx.executing = true
+	p.proceed(tx, msg.TransactionID)
+}
+
+// Seed makes the VoteNoRate draws repeatable
+func (p *Participant) Seed(seed int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rng = rand.New(rand.NewSource(seed))
+}
+
+// drawVoteNo decides whether a new transaction will vote No
+func (p *Participant) drawVoteNo() bool {
+	return p.ForceVoteNo || (p.VoteNoRate > 0 && p.rng.Float64() < p.VoteNoRate)
+}
+
+// readOnly reports whether a sub-transaction leaves nothing to commit
+func (p *Participant) readOnly(ops []protocol.Operation) bool {
+	if len(ops) == 0 {
+		return p.ReadOnly
+	}
+	for _, op := range ops {
+		if op.IsWrite() {
+			return false
+		}
+	}
+	return true
+}
+
+// proceed runs the transaction's operations from where it left off and votes
+// once all of them have run. A lock request that has to wait parks the
+// transaction until the lock manager grants it (see resume).
+func (p *Participant) proceed(tx *participantTxn, txID uuid.UUID) {
+	ops := tx.prepare.Operations
+	for ; tx.next < len(ops); tx.next++ {
+		op := ops[tx.next]
+		mode := lock.Shared
+		if op.IsWrite() {
+			mode = lock.Exclusive
+		}
+		err := p.Locks.Acquire(txID, tx.prepare.Timestamp, op.Key, mode)
+		if err == lock.ErrWait {
+			p.wait(tx, txID, op)
+			return
+		}
+		if err != nil {
+			log.Printf("[Participant %s] Cannot run %s in Tx %s: %v", p.ID, op.Type, txID, err)
+			p.reject(tx, txID)
+			return
+		}
+		if !p.execute(tx, txID, op) {
+			p.reject(tx, txID)
+			return
+		}
+	}
+	tx.executing = false
+
+	if tx.onePhase {
+		p.commitOnePhase(tx, txID)
 		return
 	}
-	// If Committed, we ignore Prepare (we must have voted Yes already)
-	if p.State != protocol.StateInit {
+	if tx.shared {
+		// Nothing to make durable and nothing to wait for: release immediately.
+		// The vote is noted, unforced, so that after a crash or once the
+		// transaction is forgotten we still know not to answer its peers.
+		if !p.writeLog(wal.RecReadOnly, txID, false) {
+			p.reject(tx, txID)
+			return
+		}
+		tx.readOnly = true
+		p.setOutcome(tx, txID, protocol.StateInit)
+		p.vote(protocol.MsgVoteReadOnly, tx.prepare, tx.results)
 		return
 	}
 
-	// Decision logic
-	vote := protocol.MsgVoteYes
-	if p.ForceVoteNo {
-		vote = protocol.MsgVoteNo
-		p.State = protocol.StateAborted
+	// The vote is a promise, so it must be durable before it is sent
+	if tx.voteNo || !p.force(wal.RecPrepared, txID) {
+		p.reject(tx, txID)
+		return
+	}
+	tx.state = protocol.StateReady
+	tx.readyTime = p.Clock.Now()
+	tx.inquiries = 0
+	p.scheduleInquiry(tx, txID)
+	p.vote(protocol.MsgVoteYes, tx.prepare, tx.results)
+	p.crashAt(CrashReady)
+}
+
+// execute runs one operation of the participant's share of a transaction,
+// under a lock it already holds. Gets see the transaction's own earlier
+// writes, and writes only reach its write set. It returns false if a
+// CompareAndSet found an unexpected value.
+func (p *Participant) execute(tx *participantTxn, txID uuid.UUID, op protocol.Operation) bool {
+	switch op.Type {
+	case protocol.OpGet:
+		op.Value, op.Found = p.Store.Read(txID, op.Key)
+		tx.results = append(tx.results, op)
+	case protocol.OpPut:
+		p.Store.Put(txID, op.Key, op.Value)
+	case protocol.OpDelete:
+		p.Store.Delete(txID, op.Key)
+	case protocol.OpCompareAndSet:
+		if current, _ := p.Store.Read(txID, op.Key); current != op.Expected {
+			log.Printf("[Participant %s] CompareAndSet on %q failed in Tx %s: found %q, expected %q", p.ID, op.Key, txID, current, op.Expected)
+			return false
+		}
+		p.Store.Put(txID, op.Key, op.Value)
+	}
+	return true
+}
+
+// reject aborts a transaction that has not voted Yes and votes No
+func (p *Participant) reject(tx *participantTxn, txID uuid.UUID) {
+	if !tx.shared {
+		p.writeLog(wal.RecAbort, txID, p.Mode.acked(protocol.MsgAbort))
+	}
+	// A read-only transaction wrote nothing, so there is nothing to log either
+	if tx.onePhase {
+		p.replyOnePhase(protocol.MsgAbort, tx.prepare, nil)
 	} else {
-		p.State = protocol.StateReady
-		p.ReadyTime = time.Now()
+		p.vote(protocol.MsgVoteNo, tx.prepare, nil)
+	}
+	// Releasing the locks may let a waiter run, so do it last
+	p.setOutcome(tx, txID, protocol.StateAborted)
+}
+
+// wait parks a transaction whose lock request was queued. Under wound-wait it
+// first wounds the younger transactions in its way. Those still running their
+// operations are aborted here. A Yes vote is a promise to commit, so for one
+// already Ready the coordinator is asked to abort it instead; if it has
+// decided, the decision frees the lock soon enough.
+func (p *Participant) wait(tx *participantTxn, txID uuid.UUID, op protocol.Operation) {
+	log.Printf("[Participant %s] Tx %s waiting for the lock on %q", p.ID, txID, op.Key)
+	switch p.Locks.Policy {
+	case lock.WaitTimeout:
+		if p.LockTimeout > 0 {
+			tx.lockWait = p.Clock.AfterFunc(p.LockTimeout, func() { p.onLockTimeout(tx, txID) })
+		}
+	case lock.WoundWait:
+		for _, victimID := range p.Locks.Wounded(txID) {
+			if !tx.executing || !p.Locks.Waiting(txID) {
+				// An earlier wound freed the lock and we have moved on
+				break
+			}
+			victim, ok := p.txns[victimID]
+			if !ok {
+				continue
+			}
+			switch {
+			case victim.executing:
+				log.Printf("[Participant %s] Tx %s wounds younger Tx %s", p.ID, txID, victimID)
+				p.abortWaiting(victim, victimID)
+			case victim.state == protocol.StateReady:
+				log.Printf("[Participant %s] Tx %s wounds younger Tx %s, which is Ready; asking %s to abort it", p.ID, txID, victimID, p.CoordinatorID)
+				p.Net.Send(protocol.Message{
+					Type:          protocol.MsgWound,
+					TransactionID: victimID,
+					FromID:        p.ID,
+					ToID:          p.CoordinatorID,
+				})
+			}
+		}
 	}
+}
 
+// resume carries on with a transaction whose queued lock request has been
+// granted. The lock manager calls it from ReleaseAll, with p.mu held.
+func (p *Participant) resume(txID uuid.UUID) {
+	tx, ok := p.txns[txID]
+	if !ok || !tx.executing {
+		return
+	}
+	stopTimer(&tx.lockWait)
+	p.proceed(tx, txID)
+}
+
+func (p *Participant) onLockTimeout(tx *participantTxn, txID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.crashed || p.txns[txID] != tx || !tx.executing || !p.Locks.Waiting(txID) {
+		return
+	}
+	log.Printf("[Participant %s] Tx %s timed out waiting for a lock", p.ID, txID)
+	p.abortWaiting(tx, txID)
+}
+
+// onVictim aborts a transaction the deadlock detector chose to break a cycle
+func (p *Participant) onVictim(txID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tx, ok := p.txns[txID]
+	if p.crashed || !ok || !tx.executing || !p.Locks.Waiting(txID) {
+		return
+	}
+	log.Printf("[Participant %s] Tx %s chosen as deadlock victim", p.ID, txID)
+	p.abortWaiting(tx, txID)
+}
+
+// abortWaiting aborts a transaction on behalf of the locking policy and votes No
+func (p *Participant) abortWaiting(tx *participantTxn, txID uuid.UUID) {
+	p.reject(tx, txID)
+	p.Locks.Abort(txID)
+}
+
+// handleCommitOnePhase runs a transaction we are the only participant of. With
+// nobody else to agree with, we decide on our own: no vote, no Ready state and
+// a single forced log record.
+func (p *Participant) handleCommitOnePhase(msg protocol.Message) {
+	if tx, known := p.txns[msg.TransactionID]; known {
+		// Idempotency: repeat the outcome; one still running replies when done
+		switch tx.state {
+		case protocol.StateCommitted:
+			p.replyOnePhase(protocol.MsgCommit, msg, tx.results)
+		case protocol.StateAborted:
+			p.replyOnePhase(protocol.MsgAbort, msg, nil)
+		}
+		return
+	}
+
+	tx := p.txn(msg.TransactionID)
+	tx.prepare = msg
+	tx.onePhase = true
+	tx.voteNo = p.drawVoteNo()
+	tx.shared = p.readOnly(msg.Operations) && !tx.voteNo
+
+	if !tx.shared {
+		p.writeLog(wal.RecBegin, msg.TransactionID, false)
+		if p.crashAt(CrashPrepare) {
+			return
+		}
+	}
+
+	tx.executing = true
+	p.proceed(tx, msg.TransactionID)
+}
+
+// commitOnePhase decides a one-phase transaction once its operations have run.
+// The Commit record carries the write set, as there is no Prepared record.
+func (p *Participant) commitOnePhase(tx *participantTxn, txID uuid.UUID) {
+	if !tx.shared {
+		if p.crashAt(CrashDecision) {
+			return
+		}
+		if tx.voteNo || !p.force(wal.RecCommit, txID) {
+			p.reject(tx, txID)
+			return
+		}
+	}
+	p.setOutcome(tx, txID, protocol.StateCommitted)
+	log.Printf("[Participant %s] COMMITTED Tx %s in one phase", p.ID, txID)
+	p.replyOnePhase(protocol.MsgCommit, tx.prepare, tx.results)
+}
+
+// handleAbortOnePhase answers a coordinator that gave up waiting for a
+// one-phase outcome. A transaction that has not committed yet never will;
+// either way the reply carries the outcome it reached.
+func (p *Participant) handleAbortOnePhase(msg protocol.Message) {
+	tx, known := p.txns[msg.TransactionID]
+	if !known {
+		// We may have committed it and forgotten it since
+		if state, _ := p.loggedOutcome(msg.TransactionID); state == protocol.StateCommitted {
+			p.replyOnePhase(protocol.MsgCommit, msg, nil)
+			return
+		}
+		tx = p.txn(msg.TransactionID)
+		tx.prepare = msg
+		tx.onePhase = true
+	}
+	switch tx.state {
+	case protocol.StateCommitted:
+		p.replyOnePhase(protocol.MsgCommit, msg, tx.results)
+	case protocol.StateAborted:
+		p.replyOnePhase(protocol.MsgAbort, msg, nil)
+	default:
+		// Still running its operations, or the CommitOnePhase never came
+		log.Printf("[Participant %s] ABORTED Tx %s at the request of %s", p.ID, msg.TransactionID, msg.FromID)
+		p.reject(tx, msg.TransactionID)
+	}
+}
+
+// replyOnePhase tells the coordinator how a one-phase transaction ended,
+// carrying back what it read
+func (p *Participant) replyOnePhase(decision protocol.MessageType, to protocol.Message, results []protocol.Operation) {
 	p.Net.Send(protocol.Message{
-		Type:          vote,
-		TransactionID: msg.TransactionID,
+		Type:          protocol.MsgOnePhaseReply,
+		TransactionID: to.TransactionID,
 		FromID:        p.ID,
-		ToID:          msg.FromID,
+		ToID:          to.FromID,
+		Decision:      decision,
+		Operations:    results,
 	})
 }
 
 func (p *Participant) handleCommit(msg protocol.Message) {
-	if p.State == protocol.StateCommitted {
-		// Idempotent: resend Ack
-		p.sendAck(msg.TransactionID, msg.FromID)
+	tx, known := p.txns[msg.TransactionID]
+	if !known || tx.state == protocol.StateCommitted {
+		// Idempotent: resend Ack. A transaction we no longer know about
+		// was finished and forgotten, so the Ack is still the right answer.
+		p.sendAck(protocol.MsgCommit, msg.TransactionID, msg.FromID)
 		return
 	}
 
-	if p.State == protocol.StateReady {
-		p.State = protocol.StateCommitted
+	if tx.state == protocol.StateReady {
+		if p.crashAt(CrashDecision) {
+			return
+		}
+		if !p.writeLog(wal.RecCommit, msg.TransactionID, p.Mode.acked(protocol.MsgCommit)) {
+			// Without an Ack the coordinator will retry the Commit
+			return
+		}
+		p.setOutcome(tx, msg.TransactionID, protocol.StateCommitted)
 		log.Printf("[Participant %s] COMMITTED Tx %s", p.ID, msg.TransactionID)
-		p.sendAck(msg.TransactionID, msg.FromID)
+		p.sendAck(protocol.MsgCommit, msg.TransactionID, msg.FromID)
 	} else {
-		log.Printf("[Participant %s] Received Commit but state is %s", p.ID, p.State)
+		log.Printf("[Participant %s] Received Commit but state is %s", p.ID, tx.state)
 	}
 }
 
 func (p *Participant) handleAbort(msg protocol.Message) {
-	if p.State == protocol.StateAborted {
+	if msg.OnePhase {
+		p.handleAbortOnePhase(msg)
+		return
+	}
+	tx := p.txn(msg.TransactionID)
+	if tx.state == protocol.StateAborted {
 		// Idempotent: resend Ack
-		p.sendAck(msg.TransactionID, msg.FromID)
+		p.sendAck(protocol.MsgAbort, msg.TransactionID, msg.FromID)
 		return
 	}
 
-	if p.State == protocol.StateReady || p.State == protocol.StateInit {
-		p.State = protocol.StateAborted
+	if tx.state == protocol.StateReady || tx.state == protocol.StateInit {
+		if p.crashAt(CrashDecision) {
+			return
+		}
+		if !p.writeLog(wal.RecAbort, msg.TransactionID, p.Mode.acked(protocol.MsgAbort)) {
+			return
+		}
+		p.setOutcome(tx, msg.TransactionID, protocol.StateAborted)
 		log.Printf("[Participant %s] ABORTED Tx %s", p.ID, msg.TransactionID)
-		p.sendAck(msg.TransactionID, msg.FromID)
+		p.sendAck(protocol.MsgAbort, msg.TransactionID, msg.FromID)
+	}
+}
+
+// handleDecisionReply applies the outcome the coordinator gave in answer to our inquiry
+func (p *Participant) handleDecisionReply(msg protocol.Message) {
+	if _, known := p.txns[msg.TransactionID]; !known {
+		return
+	}
+	switch msg.Decision {
+	case protocol.MsgCommit:
+		p.handleCommit(msg)
+	case protocol.MsgAbort:
+		p.handleAbort(msg)
+	}
+}
+
+// handlePeerDecisionRequest answers a blocked peer. Only a participant that is
+// itself in doubt stays silent; one that has not voted yet aborts so that it
+// can never vote Yes later.
+func (p *Participant) handlePeerDecisionRequest(msg protocol.Message) {
+	var state protocol.State
+	var readOnly bool
+	tx, known := p.txns[msg.TransactionID]
+	if known {
+		state, readOnly = tx.state, tx.readOnly
+	} else {
+		// We may have finished the transaction and forgotten it
+		state, readOnly = p.loggedOutcome(msg.TransactionID)
+	}
+
+	var decision protocol.MessageType
+	switch {
+	case state == protocol.StateCommitted:
+		decision = protocol.MsgCommit
+	case state == protocol.StateAborted:
+		decision = protocol.MsgAbort
+	case state == protocol.StateReady:
+		// In doubt ourselves
+		return
+	case readOnly:
+		// We voted without waiting for the outcome, so we know nothing
+		return
+	default:
+		if !p.force(wal.RecAbort, msg.TransactionID) {
+			return
+		}
+		p.setOutcome(p.txn(msg.TransactionID), msg.TransactionID, protocol.StateAborted)
+		log.Printf("[Participant %s] Unilaterally ABORTED Tx %s at the request of %s", p.ID, msg.TransactionID, msg.FromID)
+		decision = protocol.MsgAbort
+	}
+
+	p.Net.Send(protocol.Message{
+		Type:          protocol.MsgPeerDecisionReply,
+		TransactionID: msg.TransactionID,
+		FromID:        p.ID,
+		ToID:          msg.FromID,
+		Decision:      decision,
+	})
+}
+
+// handlePeerDecisionReply applies an outcome learned from a peer. The Ack still
+// goes to the coordinator so it can stop retransmitting once it is back.
+func (p *Participant) handlePeerDecisionReply(msg protocol.Message) {
+	tx, known := p.txns[msg.TransactionID]
+	if !known || tx.state != protocol.StateReady {
+		return
 	}
+	log.Printf("[Participant %s] Learned %s for Tx %s from peer %s", p.ID, msg.Decision, msg.TransactionID, msg.FromID)
+	msg.FromID = p.CoordinatorID
+	p.handleDecisionReply(msg)
 }
 
-func (p *Participant) sendAck(txID uuid.UUID, to string) {
+// loggedOutcome looks up the decision on a transaction that is no longer in
+// memory; it returns Init if the log has none, and whether we voted ReadOnly
+func (p *Participant) loggedOutcome(txID uuid.UUID) (protocol.State, bool) {
+	records, err := p.Log.Records()
+	if err != nil {
+		// Without the log we cannot rule out a vote, so claim to be in doubt
+		log.Printf("[Participant %s] Failed to read log: %v", p.ID, err)
+		return protocol.StateReady, false
+	}
+	state, readOnly := protocol.StateInit, false
+	for _, rec := range records {
+		if rec.TransactionID != txID {
+			continue
+		}
+		switch rec.Type {
+		case wal.RecPrepared:
+			state = protocol.StateReady
+		case wal.RecCommit:
+			state = protocol.StateCommitted
+		case wal.RecAbort:
+			state = protocol.StateAborted
+		case wal.RecReadOnly:
+			readOnly = true
+		}
+	}
+	return state, readOnly
+}
+
+// vote answers a Prepare, carrying back what the transaction read
+func (p *Participant) vote(msgType protocol.MessageType, to protocol.Message, results []protocol.Operation) {
+	p.Net.Send(protocol.Message{
+		Type:          msgType,
+		TransactionID: to.TransactionID,
+		FromID:        p.ID,
+		ToID:          to.FromID,
+		Operations:    results,
+	})
+}
+
+// sendAck acknowledges a decision, unless the mode presumes that outcome
+func (p *Participant) sendAck(decision protocol.MessageType, txID uuid.UUID, to string) {
+	if !p.Mode.acked(decision) {
+		return
+	}
 	ack := protocol.Message{
 		Type:          protocol.MsgAck,
 		TransactionID: txID,
@@ -146,3 +941,32 @@ func (p *Participant) sendAck(txID uuid.UUID, to string) {
 	}
 	p.Net.Send(ack)
 }
+
+// force writes a record to the log and waits for it to be durable.
+// It returns false if the write failed.
+func (p *Participant) force(recType wal.RecordType, txID uuid.UUID) bool {
+	return p.writeLog(recType, txID, true)
+}
+
+func (p *Participant) writeLog(recType wal.RecordType, txID uuid.UUID, force bool) bool {
+	rec := wal.Record{
+		Type:          recType,
+		TransactionID: txID,
+		Coordinator:   p.CoordinatorID,
+	}
+	if tx, ok := p.txns[txID]; ok && recType == wal.RecPrepared {
+		// Needed to run cooperative termination after a restart
+		rec.Participants = tx.peers
+		// Needed to redo the transaction if it commits
+		rec.Writes = p.Store.Writes(txID)
+	}
+	if tx, ok := p.txns[txID]; ok && recType == wal.RecCommit && tx.onePhase {
+		rec.Writes = p.Store.Writes(txID)
+	}
+	err := p.Log.Append(rec, force)
+	if err != nil {
+		log.Printf("[Participant %s] Failed to log %s for Tx %s: %v", p.ID, recType, txID, err)
+		return false
+	}
+	return true
+}
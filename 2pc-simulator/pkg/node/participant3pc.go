@@ -0,0 +1,406 @@
+package node
+
+import (
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/protocol"
+	"2pc-sim/pkg/transport"
+	"2pc-sim/pkg/wal"
+)
+
+// Participant3PC is a Three-Phase Commit participant. It does not wait on a
+// silent coordinator: after Timeout in PreCommitted it commits, and after
+// Timeout in Ready it runs the termination protocol with its peers, asking
+// again every Timeout until they settle the outcome.
+type Participant3PC struct {
+	ID            string
+	Net           transport.Network
+	Inbox         chan protocol.Message
+	CoordinatorID string
+	Log           wal.Log
+	Timeout       time.Duration
+	// Retention is how long a committed or aborted transaction is kept in memory
+	Retention time.Duration
+	// Clock runs the termination timeouts
+	Clock clock.Clock
+	mu    sync.Mutex
+	txns  map[uuid.UUID]*txn3PC
+	last  uuid.UUID // most recent transaction, for CurrentState
+	stop  chan struct{}
+	// Logic hooks for simulation
+	ForceVoteNo bool
+	// VoteNoRate is the chance of voting No, drawn afresh for every
+	// transaction from a generator that Seed makes repeatable
+	VoteNoRate float64
+	rng        *rand.Rand
+}
+
+// txn3PC is the participant's view of a single transaction
+type txn3PC struct {
+	state protocol.State
+	timer clock.Timer // the 3PC timeout, or once decided, the one that forgets it
+	peers []string    // other participants in the transaction
+	// uncertain are the peers that told us they are still in Ready
+	uncertain map[string]bool
+	// promised is set once we have told a peer we are in Ready. That peer may
+	// abort on the strength of it, so we must never move to PreCommitted.
+	promised bool
+}
+
+func NewParticipant3PC(id string, net transport.Network, coordinatorID string, timeout time.Duration) *Participant3PC {
+	return &Participant3PC{
+		ID:            id,
+		Net:           net,
+		Inbox:         make(chan protocol.Message, 100),
+		CoordinatorID: coordinatorID,
+		Log:           wal.NewMemoryLog(0),
+		Timeout:       timeout,
+		Retention:     DefaultRetention,
+		Clock:         clock.Real{},
+		rng:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		txns:          make(map[uuid.UUID]*txn3PC),
+		stop:          make(chan struct{}),
+	}
+}
+
+func (p *Participant3PC) Start() {
+	p.Net.Register(p.ID, p.Inbox)
+	serve(p.Clock, p.stop, p.Inbox, p.handleMessage)
+}
+
+// Seed makes the VoteNoRate draws repeatable
+func (p *Participant3PC) Seed(seed int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rng = rand.New(rand.NewSource(seed))
+}
+
+// drawVoteNo decides whether a new transaction will vote No
+func (p *Participant3PC) drawVoteNo() bool {
+	return p.ForceVoteNo || (p.VoteNoRate > 0 && p.rng.Float64() < p.VoteNoRate)
+}
+
+// State returns the participant's state for a transaction
+func (p *Participant3PC) State(txID uuid.UUID) protocol.State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if tx, ok := p.txns[txID]; ok {
+		return tx.state
+	}
+	return protocol.StateInit
+}
+
+// CurrentState returns the state of the participant's latest transaction
+func (p *Participant3PC) CurrentState() protocol.State {
+	p.mu.Lock()
+	last := p.last
+	p.mu.Unlock()
+	return p.State(last)
+}
+
+func (p *Participant3PC) handleMessage(msg protocol.Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	log.Printf("[Participant3PC %s] Rx %s from %s", p.ID, msg.Type, msg.FromID)
+
+	tx, ok := p.txns[msg.TransactionID]
+	if !ok {
+		tx = &txn3PC{state: protocol.StateInit, uncertain: make(map[string]bool)}
+		p.txns[msg.TransactionID] = tx
+		p.last = msg.TransactionID
+		if msg.Type != protocol.MsgCanCommit {
+			// We may have finished the transaction and forgotten it
+			if state := p.loggedState(msg.TransactionID); state != protocol.StateInit {
+				p.setState(tx, msg.TransactionID, state)
+			}
+		}
+	}
+
+	switch msg.Type {
+	case protocol.MsgCanCommit:
+		p.handleCanCommit(tx, msg)
+	case protocol.MsgPreCommit:
+		p.handlePreCommit(tx, msg)
+	case protocol.MsgDoCommit:
+		p.handleDoCommit(tx, msg)
+	case protocol.MsgAbort:
+		p.handleAbort(tx, msg)
+	case protocol.MsgPeerDecisionRequest:
+		p.handlePeerDecisionRequest(tx, msg)
+	case protocol.MsgPeerDecisionReply:
+		p.handlePeerDecisionReply(tx, msg)
+	default:
+		log.Printf("[Participant3PC %s] Ignoring unexpected message type %s", p.ID, msg.Type)
+	}
+}
+
+func (p *Participant3PC) handleCanCommit(tx *txn3PC, msg protocol.Message) {
+	switch tx.state {
+	case protocol.StateInit:
+		for _, id := range msg.Participants {
+			if id != p.ID {
+				tx.peers = append(tx.peers, id)
+			}
+		}
+		if p.drawVoteNo() || !p.force(wal.RecPrepared, msg.TransactionID) {
+			p.setState(tx, msg.TransactionID, protocol.StateAborted)
+			p.force(wal.RecAbort, msg.TransactionID)
+			p.reply(protocol.MsgVoteNo, msg)
+			return
+		}
+		p.setState(tx, msg.TransactionID, protocol.StateReady)
+		p.reply(protocol.MsgVoteYes, msg)
+	case protocol.StateAborted:
+		p.reply(protocol.MsgVoteNo, msg)
+	default:
+		// Idempotency: we already voted Yes
+		p.reply(protocol.MsgVoteYes, msg)
+	}
+}
+
+func (p *Participant3PC) handlePreCommit(tx *txn3PC, msg protocol.Message) {
+	switch tx.state {
+	case protocol.StateReady:
+		if tx.promised {
+			// The coordinator keeps resending; the termination protocol will
+			// settle the outcome, and then we answer
+			log.Printf("[Participant3PC %s] Ignoring PreCommit for Tx %s, a peer may abort on our word", p.ID, msg.TransactionID)
+			return
+		}
+		if !p.force(wal.RecPreCommitted, msg.TransactionID) {
+			return
+		}
+		p.setState(tx, msg.TransactionID, protocol.StatePreCommitted)
+		p.reply(protocol.MsgPreCommitAck, msg)
+	case protocol.StatePreCommitted, protocol.StateCommitted:
+		p.reply(protocol.MsgPreCommitAck, msg)
+	case protocol.StateAborted:
+		// We aborted through the termination protocol; the coordinator must too
+		p.reply(protocol.MsgVoteNo, msg)
+	default:
+		log.Printf("[Participant3PC %s] Received PreCommit but state is %s", p.ID, tx.state)
+	}
+}
+
+func (p *Participant3PC) handleDoCommit(tx *txn3PC, msg protocol.Message) {
+	switch tx.state {
+	case protocol.StateReady, protocol.StatePreCommitted:
+		// DoCommit is only sent once the coordinator has decided, so a
+		// participant that missed PreCommit can still commit
+		if !p.force(wal.RecCommit, msg.TransactionID) {
+			return
+		}
+		p.setState(tx, msg.TransactionID, protocol.StateCommitted)
+		log.Printf("[Participant3PC %s] COMMITTED Tx %s", p.ID, msg.TransactionID)
+		p.reply(protocol.MsgAck, msg)
+	case protocol.StateCommitted:
+		p.reply(protocol.MsgAck, msg)
+	default:
+		log.Printf("[Participant3PC %s] Received DoCommit but state is %s", p.ID, tx.state)
+	}
+}
+
+func (p *Participant3PC) handleAbort(tx *txn3PC, msg protocol.Message) {
+	switch tx.state {
+	case protocol.StateInit, protocol.StateReady, protocol.StatePreCommitted:
+		if !p.force(wal.RecAbort, msg.TransactionID) {
+			return
+		}
+		p.setState(tx, msg.TransactionID, protocol.StateAborted)
+		log.Printf("[Participant3PC %s] ABORTED Tx %s", p.ID, msg.TransactionID)
+		p.reply(protocol.MsgAck, msg)
+	case protocol.StateAborted:
+		p.reply(protocol.MsgAck, msg)
+	}
+}
+
+// setState moves a transaction to state and arms the 3PC timeout that applies
+// there; a committed or aborted one is forgotten after Retention
+func (p *Participant3PC) setState(tx *txn3PC, txID uuid.UUID, state protocol.State) {
+	tx.state = state
+	if tx.timer != nil {
+		tx.timer.Stop()
+		tx.timer = nil
+	}
+	switch state {
+	case protocol.StateReady, protocol.StatePreCommitted:
+		tx.timer = p.Clock.AfterFunc(p.Timeout, func() { p.onTimeout(txID, state) })
+	case protocol.StateCommitted, protocol.StateAborted:
+		if p.Retention > 0 {
+			tx.timer = p.Clock.AfterFunc(p.Retention, func() { p.forget(tx, txID) })
+		}
+	}
+}
+
+// forget drops a finished transaction from memory; its outcome stays in the log
+func (p *Participant3PC) forget(tx *txn3PC, txID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.txns[txID] == tx {
+		delete(p.txns, txID)
+	}
+}
+
+// loggedState looks up how far a transaction that is no longer in memory got
+func (p *Participant3PC) loggedState(txID uuid.UUID) protocol.State {
+	records, err := p.Log.Records()
+	if err != nil {
+		// Without the log we cannot rule out a vote, so claim to be in doubt
+		log.Printf("[Participant3PC %s] Failed to read log: %v", p.ID, err)
+		return protocol.StateReady
+	}
+	state := protocol.StateInit
+	for _, rec := range records {
+		if rec.TransactionID != txID {
+			continue
+		}
+		switch rec.Type {
+		case wal.RecPrepared:
+			state = protocol.StateReady
+		case wal.RecPreCommitted:
+			state = protocol.StatePreCommitted
+		case wal.RecCommit:
+			state = protocol.StateCommitted
+		case wal.RecAbort:
+			state = protocol.StateAborted
+		}
+	}
+	return state
+}
+
+// onTimeout applies the 3PC termination rules. One that has seen PreCommit
+// commits: it is only sent once everyone voted Yes, and nobody aborts after
+// that. One still uncertain (Ready) asks its peers instead.
+func (p *Participant3PC) onTimeout(txID uuid.UUID, state protocol.State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tx, ok := p.txns[txID]
+	if !ok || tx.state != state {
+		return
+	}
+	if state == protocol.StatePreCommitted {
+		p.decide(tx, txID, protocol.StateCommitted, "Timed out in PreCommitted")
+		return
+	}
+
+	log.Printf("[Participant3PC %s] Timed out in Ready, asking peers about Tx %s", p.ID, txID)
+	if p.terminate(tx, txID) {
+		return
+	}
+	for _, peer := range tx.peers {
+		if !tx.uncertain[peer] {
+			p.Net.Send(protocol.Message{
+				Type:          protocol.MsgPeerDecisionRequest,
+				TransactionID: txID,
+				FromID:        p.ID,
+				ToID:          peer,
+			})
+		}
+	}
+	tx.timer = p.Clock.AfterFunc(p.Timeout, func() { p.onTimeout(txID, state) })
+}
+
+// handlePeerDecisionRequest tells a peer running the termination protocol how
+// far we got. One that has not voted yet aborts, so that it never votes Yes.
+func (p *Participant3PC) handlePeerDecisionRequest(tx *txn3PC, msg protocol.Message) {
+	var answer protocol.MessageType
+	switch tx.state {
+	case protocol.StateInit:
+		if !p.decide(tx, msg.TransactionID, protocol.StateAborted, "Asked by "+msg.FromID+" before voting") {
+			return
+		}
+		answer = protocol.MsgAbort
+	case protocol.StateReady:
+		tx.promised = true
+		answer = protocol.MsgVoteYes
+	case protocol.StatePreCommitted:
+		answer = protocol.MsgPreCommit
+	case protocol.StateCommitted:
+		answer = protocol.MsgCommit
+	case protocol.StateAborted:
+		answer = protocol.MsgAbort
+	}
+	p.Net.Send(protocol.Message{
+		Type:          protocol.MsgPeerDecisionReply,
+		TransactionID: msg.TransactionID,
+		FromID:        p.ID,
+		ToID:          msg.FromID,
+		Decision:      answer,
+	})
+}
+
+// handlePeerDecisionReply applies the termination rules to a peer's answer:
+// commit if it has passed Ready, abort if it has aborted, and abort too once
+// every peer has said it is still in Ready
+func (p *Participant3PC) handlePeerDecisionReply(tx *txn3PC, msg protocol.Message) {
+	if tx.state != protocol.StateReady {
+		return
+	}
+	switch msg.Decision {
+	case protocol.MsgPreCommit, protocol.MsgCommit:
+		p.decide(tx, msg.TransactionID, protocol.StateCommitted, "Peer "+msg.FromID+" has passed Ready")
+	case protocol.MsgAbort:
+		p.decide(tx, msg.TransactionID, protocol.StateAborted, "Peer "+msg.FromID+" has aborted")
+	case protocol.MsgVoteYes:
+		tx.uncertain[msg.FromID] = true
+		p.terminate(tx, msg.TransactionID)
+	}
+}
+
+// terminate aborts once every peer has promised to stay out of PreCommitted,
+// which means nobody can commit. It reports whether it did.
+func (p *Participant3PC) terminate(tx *txn3PC, txID uuid.UUID) bool {
+	for _, peer := range tx.peers {
+		if !tx.uncertain[peer] {
+			return false
+		}
+	}
+	return p.decide(tx, txID, protocol.StateAborted, "Nobody has passed Ready")
+}
+
+// decide logs and applies an outcome reached without the coordinator
+func (p *Participant3PC) decide(tx *txn3PC, txID uuid.UUID, state protocol.State, why string) bool {
+	recType := wal.RecCommit
+	if state == protocol.StateAborted {
+		recType = wal.RecAbort
+	}
+	if !p.force(recType, txID) {
+		return false
+	}
+	p.setState(tx, txID, state)
+	log.Printf("[Participant3PC %s] %s, %s Tx %s", p.ID, why, strings.ToUpper(state.String()), txID)
+	return true
+}
+
+func (p *Participant3PC) reply(msgType protocol.MessageType, to protocol.Message) {
+	p.Net.Send(protocol.Message{
+		Type:          msgType,
+		TransactionID: to.TransactionID,
+		FromID:        p.ID,
+		ToID:          to.FromID,
+	})
+}
+
+// force writes a record to the log and waits for it to be durable.
+// It returns false if the write failed.
+func (p *Participant3PC) force(recType wal.RecordType, txID uuid.UUID) bool {
+	err := p.Log.Append(wal.Record{
+		Type:          recType,
+		TransactionID: txID,
+		Coordinator:   p.CoordinatorID,
+	}, true)
+	if err != nil {
+		log.Printf("[Participant3PC %s] Failed to log %s for Tx %s: %v", p.ID, recType, txID, err)
+		return false
+	}
+	return true
+}
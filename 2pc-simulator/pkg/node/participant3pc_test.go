@@ -0,0 +1,173 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/protocol"
+)
+
+func TestParticipant3PC_StateTransitions(t *testing.T) {
+	net := NewMockNetwork()
+	p := NewParticipant3PC("p1", net, "coord", time.Second)
+	txID := uuid.New()
+
+	steps := []struct {
+		msg   protocol.MessageType
+		state protocol.State
+		reply protocol.MessageType
+	}{
+		{protocol.MsgCanCommit, protocol.StateReady, protocol.MsgVoteYes},
+		{protocol.MsgPreCommit, protocol.StatePreCommitted, protocol.MsgPreCommitAck},
+		{protocol.MsgDoCommit, protocol.StateCommitted, protocol.MsgAck},
+	}
+	for _, step := range steps {
+		net.SentMessages = nil
+		p.handleMessage(protocol.Message{Type: step.msg, TransactionID: txID, FromID: "coord", ToID: "p1"})
+
+		if got := p.State(txID); got != step.state {
+			t.Errorf("After %s expected %s, got %s", step.msg, step.state, got)
+		}
+		if len(net.SentMessages) != 1 || net.SentMessages[0].Type != step.reply {
+			t.Errorf("After %s expected %s sent, got %v", step.msg, step.reply, net.SentMessages)
+		}
+	}
+}
+
+func TestParticipant3PC_TimeoutRules(t *testing.T) {
+	net := NewMockNetwork()
+	p := NewParticipant3PC("p1", net, "coord", 20*time.Millisecond)
+	clk := clock.NewFake(time.Time{})
+	p.Clock = clk
+
+	// Uncertain participants with nobody to ask abort when the coordinator
+	// goes quiet...
+	ready := uuid.New()
+	p.handleMessage(protocol.Message{Type: protocol.MsgCanCommit, TransactionID: ready, FromID: "coord", ToID: "p1"})
+
+	// ...while those that saw PreCommit know everyone voted Yes and commit
+	preCommitted := uuid.New()
+	p.handleMessage(protocol.Message{Type: protocol.MsgCanCommit, TransactionID: preCommitted, FromID: "coord", ToID: "p1"})
+	p.handleMessage(protocol.Message{Type: protocol.MsgPreCommit, TransactionID: preCommitted, FromID: "coord", ToID: "p1"})
+
+	clk.Advance(19 * time.Millisecond)
+	if got := p.State(ready); got != protocol.StateReady {
+		t.Errorf("Expected Ready before the timeout, got %s", got)
+	}
+	clk.Advance(time.Millisecond)
+
+	if got := p.State(ready); got != protocol.StateAborted {
+		t.Errorf("Expected Ready transaction to abort on timeout, got %s", got)
+	}
+	if got := p.State(preCommitted); got != protocol.StateCommitted {
+		t.Errorf("Expected PreCommitted transaction to commit on timeout, got %s", got)
+	}
+}
+
+func TestParticipant3PC_TerminationProtocol(t *testing.T) {
+	net := NewMockNetwork()
+	p := NewParticipant3PC("p1", net, "coord", 20*time.Millisecond)
+	clk := clock.NewFake(time.Time{})
+	p.Clock = clk
+	peers := []string{"p1", "p2", "p3"}
+
+	committed, aborted := uuid.New(), uuid.New()
+	for _, txID := range []uuid.UUID{committed, aborted} {
+		p.handleMessage(protocol.Message{Type: protocol.MsgCanCommit, TransactionID: txID, FromID: "coord", ToID: "p1", Participants: peers})
+	}
+	net.SentMessages = nil
+	clk.Advance(20 * time.Millisecond)
+	asked := 0
+	for _, msg := range net.SentMessages {
+		if msg.Type == protocol.MsgPeerDecisionRequest {
+			asked++
+		}
+	}
+	if asked != 4 {
+		t.Fatalf("Expected both peers asked about both transactions, got %v", net.SentMessages)
+	}
+	if got := p.State(aborted); got != protocol.StateReady {
+		t.Fatalf("Should stay Ready until the peers answer, got %s", got)
+	}
+
+	// A peer that has seen PreCommit means everyone voted Yes
+	p.handleMessage(protocol.Message{Type: protocol.MsgPeerDecisionReply, TransactionID: committed, FromID: "p2", ToID: "p1", Decision: protocol.MsgVoteYes})
+	p.handleMessage(protocol.Message{Type: protocol.MsgPeerDecisionReply, TransactionID: committed, FromID: "p3", ToID: "p1", Decision: protocol.MsgPreCommit})
+	if got := p.State(committed); got != protocol.StateCommitted {
+		t.Errorf("Expected Commit once a peer has passed Ready, got %s", got)
+	}
+
+	// Abort only once every peer is known to be stuck in Ready
+	p.handleMessage(protocol.Message{Type: protocol.MsgPeerDecisionReply, TransactionID: aborted, FromID: "p2", ToID: "p1", Decision: protocol.MsgVoteYes})
+	if got := p.State(aborted); got != protocol.StateReady {
+		t.Errorf("One uncertain peer is not enough to abort, got %s", got)
+	}
+	p.handleMessage(protocol.Message{Type: protocol.MsgPeerDecisionReply, TransactionID: aborted, FromID: "p3", ToID: "p1", Decision: protocol.MsgVoteYes})
+	if got := p.State(aborted); got != protocol.StateAborted {
+		t.Errorf("Expected Abort once nobody has passed Ready, got %s", got)
+	}
+
+	// The coordinator has to hear that it cannot commit
+	net.SentMessages = nil
+	p.handleMessage(protocol.Message{Type: protocol.MsgPreCommit, TransactionID: aborted, FromID: "coord", ToID: "p1"})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteNo {
+		t.Errorf("Expected VoteNo to a late PreCommit, got %v", net.SentMessages)
+	}
+}
+
+func TestParticipant3PC_PromiseBlocksPreCommit(t *testing.T) {
+	net := NewMockNetwork()
+	p := NewParticipant3PC("p2", net, "coord", time.Second)
+	txID := uuid.New()
+	p.handleMessage(protocol.Message{Type: protocol.MsgCanCommit, TransactionID: txID, FromID: "coord", ToID: "p2", Participants: []string{"p1", "p2"}})
+
+	// Having told p1 we are uncertain, p1 may abort, so we must not PreCommit
+	net.SentMessages = nil
+	p.handleMessage(protocol.Message{Type: protocol.MsgPeerDecisionRequest, TransactionID: txID, FromID: "p1", ToID: "p2"})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Decision != protocol.MsgVoteYes {
+		t.Fatalf("Expected to tell p1 we are in Ready, got %v", net.SentMessages)
+	}
+	net.SentMessages = nil
+	p.handleMessage(protocol.Message{Type: protocol.MsgPreCommit, TransactionID: txID, FromID: "coord", ToID: "p2"})
+	if got := p.State(txID); got != protocol.StateReady || len(net.SentMessages) != 0 {
+		t.Errorf("Expected PreCommit ignored after the promise, got %s and %v", got, net.SentMessages)
+	}
+
+	// A peer that never voted aborts rather than ever voting Yes
+	unseen := uuid.New()
+	net.SentMessages = nil
+	p.handleMessage(protocol.Message{Type: protocol.MsgPeerDecisionRequest, TransactionID: unseen, FromID: "p1", ToID: "p2"})
+	if got := p.State(unseen); got != protocol.StateAborted || net.SentMessages[0].Decision != protocol.MsgAbort {
+		t.Errorf("Expected an unseen transaction aborted, got %s and %v", got, net.SentMessages)
+	}
+}
+
+func TestParticipant3PC_ForgetsFinishedTransactions(t *testing.T) {
+	net := NewMockNetwork()
+	p := NewParticipant3PC("p1", net, "coord", time.Second)
+	clk := clock.NewFake(time.Time{})
+	p.Clock = clk
+	p.Retention = time.Minute
+
+	txID := uuid.New()
+	for _, msgType := range []protocol.MessageType{protocol.MsgCanCommit, protocol.MsgPreCommit, protocol.MsgDoCommit} {
+		p.handleMessage(protocol.Message{Type: msgType, TransactionID: txID, FromID: "coord", ToID: "p1", Participants: []string{"p1", "p2"}})
+	}
+	clk.Advance(time.Minute)
+	p.mu.Lock()
+	remembered := len(p.txns)
+	p.mu.Unlock()
+	if remembered != 0 {
+		t.Fatalf("Expected the committed transaction forgotten after Retention, %d still in memory", remembered)
+	}
+
+	// A peer asking later must still hear Commit, from the log
+	net.SentMessages = nil
+	p.handleMessage(protocol.Message{Type: protocol.MsgPeerDecisionRequest, TransactionID: txID, FromID: "p2", ToID: "p1"})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Decision != protocol.MsgCommit {
+		t.Errorf("Expected Commit for a forgotten committed transaction, got %v", net.SentMessages)
+	}
+}
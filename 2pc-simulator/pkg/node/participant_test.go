@@ -1,25 +1,30 @@
 package node
 
 import (
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 
+	"2pc-sim/pkg/lock"
 	"2pc-sim/pkg/protocol"
+	"2pc-sim/pkg/storage"
+	"2pc-sim/pkg/wal"
 )
 
 func TestParticipant_StateTransitions(t *testing.T) {
 	// Use NewMockNetwork from common_test.go
 	net := NewMockNetwork()
-	p := NewParticipant("p1", net, "coord")
+	p, _ := newTestParticipant("p1", net)
 
 	// 1. Initial State
-	if p.State != protocol.StateInit {
-		t.Errorf("Expected StateInit, got %s", p.State)
+	txID := uuid.New()
+	if p.State(txID) != protocol.StateInit {
+		t.Errorf("Expected StateInit, got %s", p.State(txID))
 	}
 
 	// 2. Prepare -> Ready
-	txID := uuid.New()
 	prepareMsg := protocol.Message{
 		Type:          protocol.MsgPrepare,
 		TransactionID: txID,
@@ -29,8 +34,8 @@ func TestParticipant_StateTransitions(t *testing.T) {
 
 	p.handlePrepare(prepareMsg)
 
-	if p.State != protocol.StateReady {
-		t.Errorf("Expected StateReady after Prepare, got %s", p.State)
+	if p.State(txID) != protocol.StateReady {
+		t.Errorf("Expected StateReady after Prepare, got %s", p.State(txID))
 	}
 	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteYes {
 		t.Errorf("Expected MsgVoteYes sent, got %v", net.SentMessages)
@@ -47,8 +52,8 @@ func TestParticipant_StateTransitions(t *testing.T) {
 	net.SentMessages = nil // Clear sent
 	p.handleCommit(commitMsg)
 
-	if p.State != protocol.StateCommitted {
-		t.Errorf("Expected StateCommitted after Commit, got %s", p.State)
+	if p.State(txID) != protocol.StateCommitted {
+		t.Errorf("Expected StateCommitted after Commit, got %s", p.State(txID))
 	}
 	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgAck {
 		t.Errorf("Expected MsgAck sent, got %v", net.SentMessages)
@@ -57,7 +62,7 @@ func TestParticipant_StateTransitions(t *testing.T) {
 
 func TestParticipant_ForceAbort(t *testing.T) {
 	net := NewMockNetwork()
-	p := NewParticipant("p1", net, "coord")
+	p, _ := newTestParticipant("p1", net)
 	p.ForceVoteNo = true
 
 	txID := uuid.New()
@@ -70,10 +75,854 @@ func TestParticipant_ForceAbort(t *testing.T) {
 
 	p.handlePrepare(prepareMsg)
 
-	if p.State != protocol.StateAborted {
-		t.Errorf("Expected StateAborted after Forced VoteNo, got %s", p.State)
+	if p.State(txID) != protocol.StateAborted {
+		t.Errorf("Expected StateAborted after Forced VoteNo, got %s", p.State(txID))
 	}
 	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteNo {
 		t.Errorf("Expected MsgVoteNo sent, got %v", net.SentMessages)
 	}
 }
+
+func TestParticipant_VoteNoRateIsPerTransaction(t *testing.T) {
+	votes := func(seed int64) []protocol.MessageType {
+		net := NewMockNetwork()
+		p, _ := newTestParticipant("p1", net)
+		p.VoteNoRate = 0.3
+		p.Seed(seed)
+		for i := 0; i < 200; i++ {
+			p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: uuid.New(), FromID: "coord", ToID: "p1"})
+		}
+		var out []protocol.MessageType
+		for _, msg := range net.SentMessages {
+			out = append(out, msg.Type)
+		}
+		return out
+	}
+
+	first := votes(7)
+	no := 0
+	for _, v := range first {
+		if v == protocol.MsgVoteNo {
+			no++
+		}
+	}
+	// The same participant votes both ways, at about the given rate
+	if no < 40 || no > 80 {
+		t.Errorf("Expected about 60 of 200 votes to be No, got %d", no)
+	}
+	if again := votes(7); !slices.Equal(first, again) {
+		t.Error("The same seed should replay the same votes")
+	}
+}
+
+func TestParticipant_ForcesLogBeforeVoting(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	l := wal.NewMemoryLog(0)
+	p.Log = l
+
+	txID := uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1"})
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: txID, FromID: "coord", ToID: "p1"})
+
+	records, _ := l.Records()
+	if len(records) != 3 || records[1].Type != wal.RecPrepared || records[2].Type != wal.RecCommit {
+		t.Fatalf("Expected Begin, Prepared, Commit records, got %v", records)
+	}
+	if records[1].Coordinator != "coord" {
+		t.Errorf("Prepared record should name the coordinator, got %q", records[1].Coordinator)
+	}
+	if got := l.Stats().Forces; got != 2 {
+		t.Errorf("Expected 2 forced writes, got %d", got)
+	}
+}
+
+func TestParticipant_RecoverInDoubt(t *testing.T) {
+	net := NewMockNetwork()
+	coordChan := make(chan protocol.Message, 10)
+	net.Register("coord", coordChan)
+
+	p, _ := newTestParticipant("p1", net)
+	p.Start()
+
+	txID := uuid.New()
+	p.Inbox <- protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1"}
+	if msg := <-coordChan; msg.Type != protocol.MsgVoteYes {
+		t.Fatalf("Expected VoteYes, got %s", msg.Type)
+	}
+
+	p.Crash()
+	if got := p.CurrentState(); got != protocol.StateInit {
+		t.Errorf("Crash should lose in-memory state, got %s", got)
+	}
+
+	p.Recover()
+	if got := p.CurrentState(); got != protocol.StateReady {
+		t.Errorf("Expected StateReady after recovery, got %s", got)
+	}
+
+	// An in-doubt participant asks the coordinator for the outcome
+	select {
+	case msg := <-coordChan:
+		if msg.Type != protocol.MsgDecisionRequest || msg.TransactionID != txID {
+			t.Errorf("Expected DecisionRequest for Tx %s, got %s for %s", txID, msg.Type, msg.TransactionID)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("Recovered participant did not contact the coordinator")
+	}
+}
+
+func TestParticipant_CrashBeforeVoteAbortsOnRecovery(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.CrashPoint = CrashPrepare
+	p.Start()
+
+	txID := uuid.New()
+	p.mu.Lock()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1"})
+	p.mu.Unlock()
+
+	if len(net.SentMessages) != 0 {
+		t.Fatalf("Crashed participant should not vote, sent %v", net.SentMessages)
+	}
+
+	p.Recover()
+	if got := p.CurrentState(); got != protocol.StateAborted {
+		t.Errorf("Expected unilateral abort after recovery, got %s", got)
+	}
+}
+
+func TestParticipant_InquiresWhileReady(t *testing.T) {
+	net := NewMockNetwork()
+	p, clk := newTestParticipant("p1", net)
+	p.InquiryInterval = 20 * time.Millisecond
+
+	txID := uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1"})
+
+	// The Commit was "lost", so the participant must ask for it, and keep asking
+	net.SentMessages = nil
+	clk.Advance(19 * time.Millisecond)
+	if len(net.SentMessages) != 0 {
+		t.Fatalf("Participant asked before the inquiry interval, sent %v", net.SentMessages)
+	}
+	clk.Advance(21 * time.Millisecond)
+	if len(net.SentMessages) != 2 || net.SentMessages[0].Type != protocol.MsgDecisionRequest {
+		t.Fatalf("Expected a DecisionRequest at 20ms and 40ms, got %v", net.SentMessages)
+	}
+
+	net.SentMessages = nil
+	p.handleDecisionReply(protocol.Message{Type: protocol.MsgDecisionReply, TransactionID: txID, FromID: "coord", ToID: "p1", Decision: protocol.MsgCommit})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgAck {
+		t.Errorf("Expected Ack after DecisionReply, got %v", net.SentMessages)
+	}
+	if got := p.State(txID); got != protocol.StateCommitted {
+		t.Errorf("Expected StateCommitted, got %s", got)
+	}
+
+	// Once the decision is known the inquiries stop
+	net.SentMessages = nil
+	clk.Advance(time.Second)
+	if len(net.SentMessages) != 0 {
+		t.Errorf("Participant kept asking after the decision, sent %v", net.SentMessages)
+	}
+}
+func TestParticipant_CooperativeTermination(t *testing.T) {
+	net := NewMockNetwork()
+	p, clk := newTestParticipant("p1", net)
+	p.Cooperative = true
+	p.InquiryInterval = 20 * time.Millisecond
+
+	txID := uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1", Participants: []string{"p1", "p2"}})
+
+	// The coordinator never answers its first inquiry, so with the second p1
+	// turns to p2 as well
+	clk.Advance(20 * time.Millisecond)
+	net.SentMessages = nil
+	clk.Advance(20 * time.Millisecond)
+	asked := false
+	for _, msg := range net.SentMessages {
+		if msg.Type == protocol.MsgPeerDecisionRequest && msg.ToID == "p2" && msg.TransactionID == txID {
+			asked = true
+		}
+	}
+	if !asked {
+		t.Fatalf("Expected a PeerDecisionRequest to p2, got %v", net.SentMessages)
+	}
+
+	p.handlePeerDecisionReply(protocol.Message{Type: protocol.MsgPeerDecisionReply, TransactionID: txID, FromID: "p2", ToID: "p1", Decision: protocol.MsgAbort})
+	if got := p.State(txID); got != protocol.StateAborted {
+		t.Errorf("Expected StateAborted from peer decision, got %s", got)
+	}
+}
+func TestParticipant_PeerRequestBeforeVoteAborts(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p2", net)
+
+	// p2 never saw the Prepare, so it must abort and never vote Yes
+	txID := uuid.New()
+	p.handlePeerDecisionRequest(protocol.Message{Type: protocol.MsgPeerDecisionRequest, TransactionID: txID, FromID: "p1", ToID: "p2"})
+
+	if p.State(txID) != protocol.StateAborted {
+		t.Errorf("Expected StateAborted, got %s", p.State(txID))
+	}
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Decision != protocol.MsgAbort {
+		t.Fatalf("Expected PeerDecisionReply(Abort), got %v", net.SentMessages)
+	}
+
+	net.SentMessages = nil
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p2"})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteNo {
+		t.Errorf("Expected VoteNo after unilateral abort, got %v", net.SentMessages)
+	}
+}
+
+func TestParticipant_PresumedAbortSkipsAck(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.Mode = ModePresumedAbort
+	l := wal.NewMemoryLog(0)
+	p.Log = l
+
+	txID := uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1"})
+	net.SentMessages = nil
+	p.handleAbort(protocol.Message{Type: protocol.MsgAbort, TransactionID: txID, FromID: "coord", ToID: "p1"})
+
+	if p.State(txID) != protocol.StateAborted {
+		t.Errorf("Expected StateAborted, got %s", p.State(txID))
+	}
+	if len(net.SentMessages) != 0 {
+		t.Errorf("Presumed abort should not acknowledge aborts, sent %v", net.SentMessages)
+	}
+	// Only the Prepared record is forced
+	if got := l.Stats().Forces; got != 1 {
+		t.Errorf("Expected 1 forced write, got %d", got)
+	}
+}
+
+func TestParticipant_ReadOnlyVote(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.ReadOnly = true
+	l := wal.NewMemoryLog(0)
+	p.Log = l
+
+	txID := uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1"})
+
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteReadOnly {
+		t.Fatalf("Expected MsgVoteReadOnly sent, got %v", net.SentMessages)
+	}
+	if p.State(txID) != protocol.StateInit {
+		t.Errorf("Read-only participant should not enter Ready, got %s", p.State(txID))
+	}
+	if got := l.Stats().Forces; got != 0 {
+		t.Errorf("Read-only participant should not force its log, got %d forces", got)
+	}
+}
+
+func TestParticipant_ReadOnlyFlagKeepsWrites(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.ReadOnly = true
+
+	// The flag only stands in for operations; a Put must still be committed
+	txID := uuid.New()
+	put := []protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "1"}}
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1", Operations: put})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteYes {
+		t.Fatalf("Expected MsgVoteYes for a write, got %v", net.SentMessages)
+	}
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: txID, FromID: "coord", ToID: "p1"})
+	if v, ok := p.Store.Get("a"); !ok || v != "1" {
+		t.Errorf("Expected the write applied, got %q, %v", v, ok)
+	}
+}
+
+func TestParticipant_MeasuresBlockingTime(t *testing.T) {
+	net := NewMockNetwork()
+	p, clk := newTestParticipant("p1", net)
+	times := make(map[uuid.UUID]time.Duration)
+	p.OnBlocked = func(txID uuid.UUID, d time.Duration) { times[txID] = d }
+
+	blocked, aborted, readOnly := uuid.New(), uuid.New(), uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: blocked, FromID: "coord", ToID: "p1"})
+	clk.Advance(20 * time.Millisecond)
+	if _, ok := times[blocked]; ok {
+		t.Error("A transaction still in Ready should not be measured yet")
+	}
+	if open := p.StillBlocked(); len(open) != 1 || open[blocked] != 20*time.Millisecond {
+		t.Errorf("Expected the open window of 20ms, got %v", open)
+	}
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: blocked, FromID: "coord", ToID: "p1"})
+
+	p.ForceVoteNo = true
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: aborted, FromID: "coord", ToID: "p1"})
+	p.ForceVoteNo = false
+	p.ReadOnly = true
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: readOnly, FromID: "coord", ToID: "p1"})
+
+	if d := times[blocked]; d != 20*time.Millisecond {
+		t.Errorf("Expected 20ms blocked in Ready, got %v", d)
+	}
+	// Neither a no vote nor a read-only vote ever blocks
+	if len(times) != 1 {
+		t.Errorf("Only the yes vote should be measured, got %v", times)
+	}
+	if n, total := p.BlockingTime(); n != 1 || total != 20*time.Millisecond {
+		t.Errorf("Expected 1 transaction blocked for 20ms in total, got %d for %v", n, total)
+	}
+	if open := p.StillBlocked(); len(open) != 0 {
+		t.Errorf("No window should be open after the decision, got %v", open)
+	}
+
+	// Forgetting the transactions keeps the totals
+	clk.Advance(p.Retention)
+	if n, total := p.BlockingTime(); n != 1 || total != 20*time.Millisecond {
+		t.Errorf("Totals should outlive the transactions, got %d for %v", n, total)
+	}
+}
+
+func TestParticipant_IndependentTransactions(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+
+	first, second := uuid.New(), uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: first, FromID: "coord", ToID: "p1"})
+	p.handleAbort(protocol.Message{Type: protocol.MsgAbort, TransactionID: first, FromID: "coord", ToID: "p1"})
+
+	// A later transaction gets a fresh vote, not the stale answer for the first
+	net.SentMessages = nil
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: second, FromID: "coord", ToID: "p1"})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteYes {
+		t.Fatalf("Expected MsgVoteYes for the second Tx, got %v", net.SentMessages)
+	}
+
+	if got := p.State(first); got != protocol.StateAborted {
+		t.Errorf("Expected first Tx Aborted, got %s", got)
+	}
+	if got := p.State(second); got != protocol.StateReady {
+		t.Errorf("Expected second Tx Ready, got %s", got)
+	}
+	if got := p.CurrentState(); got != protocol.StateReady {
+		t.Errorf("Expected CurrentState to follow the latest Tx, got %s", got)
+	}
+}
+
+func TestParticipant_ForgetsFinishedTransactions(t *testing.T) {
+	net := NewMockNetwork()
+	p, clk := newTestParticipant("p1", net)
+	p.Retention = 20 * time.Millisecond
+
+	txID := uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1"})
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: txID, FromID: "coord", ToID: "p1"})
+
+	clk.Advance(19 * time.Millisecond)
+	if _, ok := p.txns[txID]; !ok {
+		t.Fatal("Finished transaction should be kept for the retention period")
+	}
+	clk.Advance(time.Millisecond)
+	if _, ok := p.txns[txID]; ok {
+		t.Fatal("Finished transaction should have been forgotten")
+	}
+
+	// A retransmitted Commit is still acknowledged
+	net.SentMessages = nil
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: txID, FromID: "coord", ToID: "p1"})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgAck {
+		t.Errorf("Expected MsgAck for a forgotten Tx, got %v", net.SentMessages)
+	}
+
+	// A peer asking about it is answered from the log
+	net.SentMessages = nil
+	p.handlePeerDecisionRequest(protocol.Message{Type: protocol.MsgPeerDecisionRequest, TransactionID: txID, FromID: "p2", ToID: "p1"})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Decision != protocol.MsgCommit {
+		t.Errorf("Expected PeerDecisionReply(Commit) from the log, got %v", net.SentMessages)
+	}
+}
+
+func TestParticipant_WriteSetFollowsDecision(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	l := wal.NewMemoryLog(0)
+	p.Log = l
+
+	committed, aborted := uuid.New(), uuid.New()
+	p.Store.Put(committed, "a", "1")
+	p.Store.Put(aborted, "b", "2")
+	for _, txID := range []uuid.UUID{committed, aborted} {
+		p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1"})
+	}
+	if _, ok := p.Store.Get("a"); ok {
+		t.Error("Prepared writes should not be visible before Commit")
+	}
+
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: committed, FromID: "coord", ToID: "p1"})
+	p.handleAbort(protocol.Message{Type: protocol.MsgAbort, TransactionID: aborted, FromID: "coord", ToID: "p1"})
+
+	if v, ok := p.Store.Get("a"); !ok || v != "1" {
+		t.Errorf("Expected a=1 after Commit, got %q", v)
+	}
+	if _, ok := p.Store.Get("b"); ok {
+		t.Error("Aborted write should be discarded")
+	}
+
+	records, _ := l.Records()
+	if len(records[1].Writes) != 1 || records[1].Writes[0].Key != "a" {
+		t.Errorf("Prepared record should carry the write set, got %+v", records[1])
+	}
+}
+
+func TestParticipant_RecoverRedoesWrites(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.Start()
+
+	committed, inDoubt := uuid.New(), uuid.New()
+	p.Store.Put(committed, "a", "1")
+	p.Store.Put(inDoubt, "a", "2")
+	p.mu.Lock()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: committed, FromID: "coord", ToID: "p1"})
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: committed, FromID: "coord", ToID: "p1"})
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: inDoubt, FromID: "coord", ToID: "p1"})
+	p.mu.Unlock()
+
+	p.Crash()
+	if p.Store.Len() != 0 {
+		t.Fatal("Crash should lose the in-memory store")
+	}
+
+	p.Recover()
+	if v, _ := p.Store.Get("a"); v != "1" {
+		t.Errorf("Expected committed a=1 to be redone, got %q", v)
+	}
+
+	// The in-doubt write set is back, waiting for the decision
+	p.Inbox <- protocol.Message{Type: protocol.MsgCommit, TransactionID: inDoubt, FromID: "coord", ToID: "p1"}
+	deadline := time.After(200 * time.Millisecond)
+	for {
+		if v, _ := p.Store.Get("a"); v == "2" {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("In-doubt write set was not applied on Commit after recovery")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestParticipant_ExecutesOperations(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.Store.Apply([]storage.Write{{Key: "balance", Value: "10"}})
+
+	txID := uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1", Operations: []protocol.Operation{
+		{Type: protocol.OpCompareAndSet, Key: "balance", Expected: "10", Value: "5"},
+		{Type: protocol.OpGet, Key: "balance"},
+		{Type: protocol.OpDelete, Key: "missing"},
+	}})
+
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteYes {
+		t.Fatalf("Expected MsgVoteYes sent, got %v", net.SentMessages)
+	}
+	results := net.SentMessages[0].Operations
+	if len(results) != 1 || !results[0].Found || results[0].Value != "5" {
+		t.Errorf("Expected the Get to read its own write, got %+v", results)
+	}
+
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: txID, FromID: "coord", ToID: "p1"})
+	if v, _ := p.Store.Get("balance"); v != "5" {
+		t.Errorf("Expected balance=5 after Commit, got %q", v)
+	}
+
+	// A CompareAndSet that sees a different value votes No
+	net.SentMessages = nil
+	failed := uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: failed, FromID: "coord", ToID: "p1", Operations: []protocol.Operation{
+		{Type: protocol.OpPut, Key: "other", Value: "x"},
+		{Type: protocol.OpCompareAndSet, Key: "balance", Expected: "10", Value: "0"},
+	}})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteNo {
+		t.Fatalf("Expected MsgVoteNo for a failed CompareAndSet, got %v", net.SentMessages)
+	}
+	if len(p.Store.Writes(failed)) != 0 {
+		t.Error("Vote No should discard the write set")
+	}
+}
+
+func TestParticipant_OnlyGetsVotesReadOnly(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	l := wal.NewMemoryLog(0)
+	p.Log = l
+	p.Store.Apply([]storage.Write{{Key: "a", Value: "1"}})
+
+	txID := uuid.New()
+	prepare := protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1", Operations: []protocol.Operation{{Type: protocol.OpGet, Key: "a"}}}
+	p.handlePrepare(prepare)
+	p.handlePrepare(prepare)
+
+	if len(net.SentMessages) != 2 {
+		t.Fatalf("Expected two votes, got %v", net.SentMessages)
+	}
+	for _, vote := range net.SentMessages {
+		if vote.Type != protocol.MsgVoteReadOnly || len(vote.Operations) != 1 || vote.Operations[0].Value != "1" {
+			t.Errorf("Expected MsgVoteReadOnly with a=1, got %s %+v", vote.Type, vote.Operations)
+		}
+	}
+	if got := l.Stats().Forces; got != 0 {
+		t.Errorf("Read-only participant should not force its log, got %d forces", got)
+	}
+
+	// The outcome is unknown to us, so a blocked peer gets no answer
+	net.SentMessages = nil
+	p.handlePeerDecisionRequest(protocol.Message{Type: protocol.MsgPeerDecisionRequest, TransactionID: txID, FromID: "p2", ToID: "p1"})
+	if len(net.SentMessages) != 0 {
+		t.Errorf("Read-only participant should not answer peers, sent %v", net.SentMessages)
+	}
+}
+
+func TestParticipant_ReadOnlyVoterStaysSilentAfterRestart(t *testing.T) {
+	net := NewMockNetwork()
+	p, clk := newTestParticipant("p1", net)
+	p.Retention = 20 * time.Millisecond
+
+	forgotten, restarted := uuid.New(), uuid.New()
+	get := []protocol.Operation{{Type: protocol.OpGet, Key: "a"}}
+	for _, txID := range []uuid.UUID{forgotten, restarted} {
+		p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1", Operations: get})
+	}
+
+	// The first is forgotten, the second lost in a crash, and both have since
+	// been committed by the others; a blocked peer must not be told Abort
+	clk.Advance(20 * time.Millisecond)
+	p.Crash()
+	p.Recover()
+	net.SentMessages = nil
+	for _, txID := range []uuid.UUID{forgotten, restarted} {
+		p.handlePeerDecisionRequest(protocol.Message{Type: protocol.MsgPeerDecisionRequest, TransactionID: txID, FromID: "p2", ToID: "p1"})
+		if got := p.State(txID); got == protocol.StateAborted {
+			t.Errorf("Read-only voter should not abort Tx %s on a peer's request", txID)
+		}
+	}
+	for _, msg := range net.SentMessages {
+		if msg.Type == protocol.MsgPeerDecisionReply {
+			t.Errorf("Read-only voter should not answer peers, sent %v", msg)
+		}
+	}
+}
+
+func TestParticipant_LockConflictVotesNo(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+
+	holder, other := uuid.New(), uuid.New()
+	put := []protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "1"}}
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: holder, FromID: "coord", ToID: "p1", Operations: put})
+
+	// The holder is Ready and keeps its exclusive lock through the blocking window
+	net.SentMessages = nil
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: other, FromID: "coord", ToID: "p1",
+		Operations: []protocol.Operation{{Type: protocol.OpGet, Key: "a"}}})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteNo {
+		t.Fatalf("Expected MsgVoteNo on a lock conflict, got %v", net.SentMessages)
+	}
+
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: holder, FromID: "coord", ToID: "p1"})
+	if p.Locks.Holds(holder, "a") {
+		t.Error("Locks should be released at Commit")
+	}
+	if stats := p.Locks.Stats(); stats.Holds != 1 || stats.Conflicts != 1 {
+		t.Errorf("Expected 1 released hold and 1 conflict, got %+v", stats)
+	}
+}
+
+func TestParticipant_RecoverRelocksInDoubtWrites(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.Start()
+
+	txID := uuid.New()
+	p.mu.Lock()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: "p1",
+		Operations: []protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "1"}}})
+	p.mu.Unlock()
+
+	p.Crash()
+	if p.Locks.Holds(txID, "a") {
+		t.Fatal("Crash should lose the lock table")
+	}
+	p.Recover()
+	if !p.Locks.Holds(txID, "a") {
+		t.Error("An in-doubt transaction must get its write locks back on recovery")
+	}
+}
+
+func TestParticipant_WaitsForLock(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.Locks.Policy = lock.WaitTimeout
+	p.LockTimeout = time.Minute
+
+	holder, reader := uuid.New(), uuid.New()
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: holder, FromID: "coord", ToID: "p1",
+		Operations: []protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "1"}}})
+
+	net.SentMessages = nil
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: reader, FromID: "coord", ToID: "p1",
+		Operations: []protocol.Operation{{Type: protocol.OpGet, Key: "a"}}})
+	if len(net.SentMessages) != 0 {
+		t.Fatalf("A waiting transaction should not vote yet, got %v", net.SentMessages)
+	}
+
+	// The holder's Commit hands the lock over and the reader sees its write
+	p.handleCommit(protocol.Message{Type: protocol.MsgCommit, TransactionID: holder, FromID: "coord", ToID: "p1"})
+	var vote *protocol.Message
+	for i := range net.SentMessages {
+		if net.SentMessages[i].TransactionID == reader {
+			vote = &net.SentMessages[i]
+		}
+	}
+	if vote == nil || vote.Type != protocol.MsgVoteReadOnly {
+		t.Fatalf("Expected the reader to vote ReadOnly once granted, got %v", net.SentMessages)
+	}
+	if len(vote.Operations) != 1 || vote.Operations[0].Value != "1" {
+		t.Errorf("Expected the reader to see the committed write, got %+v", vote.Operations)
+	}
+	if stats := p.Locks.Stats(); stats.Waits != 1 || stats.Aborts != 0 {
+		t.Errorf("Expected 1 wait and no aborts, got %+v", stats)
+	}
+}
+
+func TestParticipant_LockTimeoutVotesNo(t *testing.T) {
+	net := NewMockNetwork()
+	p, clk := newTestParticipant("p1", net)
+	p.Locks.Policy = lock.WaitTimeout
+	p.LockTimeout = 20 * time.Millisecond
+
+	holder, other := uuid.New(), uuid.New()
+	put := []protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "1"}}
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: holder, FromID: "coord", ToID: "p1", Operations: put})
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: other, FromID: "coord", ToID: "p1", Operations: put})
+
+	clk.Advance(19 * time.Millisecond)
+	if !p.Locks.Waiting(other) {
+		t.Fatal("The waiter should still be waiting before the lock timeout")
+	}
+	clk.Advance(time.Millisecond)
+	if state := p.State(other); state != protocol.StateAborted {
+		t.Errorf("Expected the waiter to abort after the lock timeout, got %s", state)
+	}
+	last := net.SentMessages[len(net.SentMessages)-1]
+	if last.TransactionID != other || last.Type != protocol.MsgVoteNo {
+		t.Errorf("Expected MsgVoteNo for the waiter, got %v", last)
+	}
+	if stats := p.Locks.Stats(); stats.Aborts != 1 {
+		t.Errorf("Expected 1 policy abort, got %+v", stats)
+	}
+}
+
+func TestParticipant_WaitDie(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.Locks.Policy = lock.WaitDie
+
+	older, holder, younger := uuid.New(), uuid.New(), uuid.New()
+	put := []protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "1"}}
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: holder, FromID: "coord", ToID: "p1", Operations: put, Timestamp: 2})
+
+	net.SentMessages = nil
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: older, FromID: "coord", ToID: "p1", Operations: put, Timestamp: 1})
+	if len(net.SentMessages) != 0 || !p.Locks.Waiting(older) {
+		t.Fatalf("An older transaction should wait, got %v", net.SentMessages)
+	}
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: younger, FromID: "coord", ToID: "p1", Operations: put, Timestamp: 3})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Type != protocol.MsgVoteNo {
+		t.Fatalf("A younger transaction should die, got %v", net.SentMessages)
+	}
+}
+
+func TestParticipant_WoundWait(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.Locks.Policy = lock.WoundWait
+
+	older, young, youngest := uuid.New(), uuid.New(), uuid.New()
+	// youngest is Ready on a; young takes b and then waits behind it for a
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: youngest, FromID: "coord", ToID: "p1", Timestamp: 3,
+		Operations: []protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "3"}}})
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: young, FromID: "coord", ToID: "p1", Timestamp: 2,
+		Operations: []protocol.Operation{{Type: protocol.OpPut, Key: "b", Value: "2"}, {Type: protocol.OpPut, Key: "a", Value: "2"}}})
+	if !p.Locks.Waiting(young) {
+		t.Fatal("A Ready holder cannot be aborted here, so young should wait")
+	}
+	if last := net.SentMessages[len(net.SentMessages)-1]; last.Type != protocol.MsgWound || last.TransactionID != youngest || last.ToID != "coord" {
+		t.Errorf("Expected the coordinator to be asked to abort youngest, got %v", last)
+	}
+
+	// older wounds young, which has not voted, and takes b
+	net.SentMessages = nil
+	p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: older, FromID: "coord", ToID: "p1", Timestamp: 1,
+		Operations: []protocol.Operation{{Type: protocol.OpPut, Key: "b", Value: "1"}}})
+	if state := p.State(young); state != protocol.StateAborted {
+		t.Errorf("Expected the wounded transaction to abort, got %s", state)
+	}
+	if state := p.State(older); state != protocol.StateReady {
+		t.Errorf("Expected the older transaction to be Ready, got %s", state)
+	}
+	if len(net.SentMessages) != 2 || net.SentMessages[0].Type != protocol.MsgVoteNo || net.SentMessages[1].Type != protocol.MsgVoteYes {
+		t.Errorf("Expected VoteNo for the wounded and VoteYes for the older, got %v", net.SentMessages)
+	}
+	if stats := p.Locks.Stats(); stats.Aborts != 1 {
+		t.Errorf("Expected 1 policy abort, got %+v", stats)
+	}
+}
+
+func TestParticipant_WoundWaitAcrossParticipants(t *testing.T) {
+	net := NewMockNetwork()
+	p1, _ := newTestParticipant("p1", net)
+	p2, _ := newTestParticipant("p2", net)
+	for _, p := range []*Participant{p1, p2} {
+		p.Locks.Policy = lock.WoundWait
+	}
+	prepare := func(p *Participant, txID uuid.UUID, ts int64, key string) {
+		p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: p.ID, Timestamp: ts,
+			Operations: []protocol.Operation{{Type: protocol.OpPut, Key: key, Value: "v"}}})
+	}
+
+	// The younger t2 is Ready on a at p1 and the older t1 Ready on b at p2.
+	// Then t2 waits for b at p2, as younger transactions do, and t1 for a at p1.
+	older, younger := uuid.New(), uuid.New()
+	prepare(p1, younger, 2, "a")
+	prepare(p2, older, 1, "b")
+	prepare(p2, younger, 2, "b")
+	net.SentMessages = nil
+	prepare(p1, older, 1, "a")
+	if !p1.Locks.Waiting(older) || !p2.Locks.Waiting(younger) {
+		t.Fatal("Each transaction should wait for the other")
+	}
+
+	// t2 has voted Yes at p1, so p1 asks the coordinator to abort it
+	if len(net.SentMessages) != 1 {
+		t.Fatalf("Expected one wound, got %v", net.SentMessages)
+	}
+	if wound := net.SentMessages[0]; wound.Type != protocol.MsgWound || wound.TransactionID != younger || wound.ToID != "coord" {
+		t.Fatalf("Expected p1 to ask the coordinator to abort t2, got %v", wound)
+	}
+
+	// The coordinator has not decided t2, so it aborts it everywhere
+	net.SentMessages = nil
+	for _, p := range []*Participant{p1, p2} {
+		p.handleAbort(protocol.Message{Type: protocol.MsgAbort, TransactionID: younger, FromID: "coord", ToID: p.ID})
+	}
+	if state := p1.State(older); state != protocol.StateReady {
+		t.Errorf("Expected t1 to get the lock and vote at p1, got %s", state)
+	}
+	if p2.Locks.Waiting(younger) {
+		t.Error("The aborted t2 should no longer wait at p2")
+	}
+	if stats := p1.Locks.Stats(); stats.Aborts != 0 {
+		t.Errorf("The coordinator aborted t2, not p1's policy, got %+v", stats)
+	}
+}
+
+func TestParticipant_DetectorBreaksDistributedDeadlock(t *testing.T) {
+	net := NewMockNetwork()
+	p1, clk := newTestParticipant("p1", net)
+	p2, _ := newTestParticipant("p2", net)
+	detector := lock.NewDetector()
+	detector.Clock = clk
+	for _, p := range []*Participant{p1, p2} {
+		p.Locks.Policy = lock.Detect
+		detector.Register(p.Locks)
+	}
+
+	// t1 locks a on p1 and t2 locks b on p2; then each wants the other's key
+	t1, t2 := uuid.New(), uuid.New()
+	prepare := func(p *Participant, txID uuid.UUID, ts int64, keys ...string) {
+		var ops []protocol.Operation
+		for _, k := range keys {
+			ops = append(ops, protocol.Operation{Type: protocol.OpPut, Key: k, Value: "v"})
+		}
+		p.handlePrepare(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, FromID: "coord", ToID: p.ID, Operations: ops, Timestamp: ts})
+	}
+	prepare(p1, t1, 1, "a")
+	prepare(p2, t2, 2, "b")
+	prepare(p2, t1, 1, "b")
+	prepare(p1, t2, 2, "a")
+
+	// Neither participant sees a cycle; the detector aborts the younger t2
+	clk.Advance(0)
+	if detector.Deadlocks() != 1 {
+		t.Fatalf("Expected 1 deadlock, got %d", detector.Deadlocks())
+	}
+	if state := p1.State(t2); state != protocol.StateAborted {
+		t.Errorf("Expected the victim to abort at p1, got %s", state)
+	}
+	if p2.Locks.Waiting(t1) != true {
+		t.Error("t1 keeps waiting at p2 until the coordinator aborts t2 there")
+	}
+}
+
+func TestParticipant_CommitOnePhase(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.Start()
+
+	txID := uuid.New()
+	msg := protocol.Message{Type: protocol.MsgCommitOnePhase, TransactionID: txID, FromID: "coord", ToID: "p1",
+		Operations: []protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "1"}, {Type: protocol.OpGet, Key: "a"}}}
+	p.mu.Lock()
+	p.handleCommitOnePhase(msg)
+	p.handleCommitOnePhase(msg) // A retransmission gets the same answer
+	p.mu.Unlock()
+
+	if len(net.SentMessages) != 2 {
+		t.Fatalf("Expected one reply per request, got %v", net.SentMessages)
+	}
+	for _, reply := range net.SentMessages {
+		if reply.Type != protocol.MsgOnePhaseReply || reply.Decision != protocol.MsgCommit {
+			t.Errorf("Expected a Commit OnePhaseReply, got %s %s", reply.Type, reply.Decision)
+		}
+		if len(reply.Operations) != 1 || reply.Operations[0].Value != "1" {
+			t.Errorf("Expected the Get to read its own write, got %+v", reply.Operations)
+		}
+	}
+	if v, _ := p.Store.Get("a"); v != "1" {
+		t.Errorf("Expected a=1 after a one-phase commit, got %q", v)
+	}
+
+	// No Prepared record: the Commit record alone carries the write set
+	records, _ := p.Log.Records()
+	for _, rec := range records {
+		if rec.Type == wal.RecPrepared {
+			t.Error("One-phase commit should not log Prepared")
+		}
+	}
+	p.Crash()
+	p.Recover()
+	if v, _ := p.Store.Get("a"); v != "1" {
+		t.Errorf("Expected a=1 to be redone from the Commit record, got %q", v)
+	}
+}
+
+func TestParticipant_OnePhaseAbortReplies(t *testing.T) {
+	net := NewMockNetwork()
+	p, _ := newTestParticipant("p1", net)
+	p.ForceVoteNo = true
+
+	p.handleCommitOnePhase(protocol.Message{Type: protocol.MsgCommitOnePhase, TransactionID: uuid.New(), FromID: "coord", ToID: "p1",
+		Operations: []protocol.Operation{{Type: protocol.OpPut, Key: "a", Value: "1"}}})
+	if len(net.SentMessages) != 1 || net.SentMessages[0].Decision != protocol.MsgAbort {
+		t.Fatalf("Expected an Abort OnePhaseReply, got %v", net.SentMessages)
+	}
+	if _, ok := p.Store.Get("a"); ok {
+		t.Error("An aborted one-phase transaction must leave no writes")
+	}
+}
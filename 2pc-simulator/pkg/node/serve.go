@@ -0,0 +1,52 @@
+package node
+
+import (
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/protocol"
+)
+
+// server is a clock that runs message loops itself, as a sim.Scheduler does
+type server interface {
+	Serve(stop <-chan struct{}, poll func() bool)
+}
+
+// serve hands every message that reaches inbox to handle until stop is
+// closed. Normally that is a goroutine of its own; under a discrete-event
+// scheduler it is the scheduler's goroutine, right after the event that
+// delivered the message, so the whole run stays on one goroutine.
+func serve(clk clock.Clock, stop chan struct{}, inbox chan protocol.Message, handle func(protocol.Message)) {
+	if s, ok := clk.(server); ok {
+		s.Serve(stop, func() bool {
+			select {
+			case msg := <-inbox:
+				handle(msg)
+				return true
+			default:
+				return false
+			}
+		})
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case msg := <-inbox:
+				select {
+				case <-stop:
+					// Both were ready and we lost the draw; the message is the
+					// next incarnation's, so put it back for its loop
+					select {
+					case inbox <- msg:
+					default:
+					}
+					return
+				default:
+				}
+				handle(msg)
+			}
+		}
+	}()
+}
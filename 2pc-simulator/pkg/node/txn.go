@@ -0,0 +1,73 @@
+package node
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
+)
+
+// Txn is a handle on a transaction started with Begin
+type Txn struct {
+	ID uuid.UUID
+	// Participants are the nodes taking part, only those owning a key it
+	// touches when it runs operations
+	Participants []string
+	// OnePhase is set when the transaction was handed to its only participant
+	// to decide in one phase
+	OnePhase  bool
+	clock     clock.Clock
+	start     time.Time
+	done      chan struct{}
+	committed bool
+	duration  time.Duration
+	reads     map[string]string // what the transaction's Gets found
+	// Where the time went, filled in by the coordinator: Voting runs from
+	// Prepare to the decision (to the reply, in one phase; through PreCommit,
+	// in 3PC) and Acking from the decision to the last Ack
+	Voting time.Duration
+	Acking time.Duration
+	// Retries counts the messages the coordinator had to resend
+	Retries int
+}
+
+func newTxn(clk clock.Clock) *Txn {
+	return &Txn{
+		ID:    uuid.New(),
+		clock: clk,
+		start: clk.Now(),
+		done:  make(chan struct{}),
+		reads: make(map[string]string),
+	}
+}
+
+// Done returns a channel that is closed once the outcome is known
+func (t *Txn) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait blocks until the transaction has finished
+// Returns true if committed, false if aborted, and how long it took
+func (t *Txn) Wait() (bool, time.Duration) {
+	<-t.done
+	return t.committed, t.duration
+}
+
+// Read returns the value a Get in the transaction read; call it after Wait
+func (t *Txn) Read(key string) (string, bool) {
+	v, ok := t.reads[key]
+	return v, ok
+}
+
+// resolve records the outcome and wakes up waiters; later calls are ignored
+func (t *Txn) resolve(committed bool) {
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+	t.committed = committed
+	t.duration = t.clock.Since(t.start)
+	close(t.done)
+}
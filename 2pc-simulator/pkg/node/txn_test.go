@@ -0,0 +1,31 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"2pc-sim/pkg/clock"
+)
+
+func TestTxnResolve(t *testing.T) {
+	clk := clock.NewFake(time.Time{})
+	tx := newTxn(clk)
+
+	select {
+	case <-tx.Done():
+		t.Fatal("New transaction should not be done")
+	default:
+	}
+
+	clk.Advance(5 * time.Millisecond)
+	tx.resolve(true)
+	tx.resolve(false) // Only the first outcome counts
+
+	committed, duration := tx.Wait()
+	if !committed {
+		t.Error("Expected Commit")
+	}
+	if duration != 5*time.Millisecond {
+		t.Errorf("Expected duration of 5ms, got %v", duration)
+	}
+}
@@ -0,0 +1,162 @@
+package partition
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Partitioner maps a key to the participant that owns it
+type Partitioner interface {
+	Owner(key string) string
+}
+
+// DefaultReplicas is how many points each node gets on a Ring
+const DefaultReplicas = 64
+
+// Ring is a consistent hash ring. Each node is placed at several points
+// (virtual nodes) and a key belongs to the first point at or after its hash,
+// so adding or removing a node only moves the keys next to its points.
+type Ring struct {
+	mu       sync.RWMutex
+	replicas int
+	hashes   []uint32 // sorted points on the ring
+	owners   map[uint32]string
+}
+
+// NewRing places nodes on a ring with replicas points each
+func NewRing(nodes []string, replicas int) *Ring {
+	if replicas < 1 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{replicas: replicas, owners: make(map[uint32]string)}
+	for _, n := range nodes {
+		r.Add(n)
+	}
+	return r
+}
+
+// hash is FNV-1a followed by murmur3's finalizer. FNV alone barely mixes the
+// last bytes of a string, so names that differ only in a trailing digit, as
+// both point names and the simulator's keys do, bunch up on the ring.
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// Add places a node on the ring
+func (r *Ring) Add(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < r.replicas; i++ {
+		h := hash(node + "#" + strconv.Itoa(i))
+		if _, taken := r.owners[h]; taken {
+			// A collision keeps the first owner; the node just has one point fewer
+			continue
+		}
+		r.owners[h] = node
+		r.hashes = append(r.hashes, h)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Remove takes a node off the ring; its keys move to the next points
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.owners[h] == node {
+			delete(r.owners, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+}
+
+// Owner returns the node owning key, or "" if the ring is empty
+func (r *Ring) Owner(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Range is one entry of a routing table: keys from Start (inclusive) up to the
+// next range's Start belong to Owner
+type Range struct {
+	Start string
+	Owner string
+}
+
+// Table is a routing table of key ranges in key order. Keys in the same range
+// live together, so transactions over nearby keys touch few participants.
+type Table struct {
+	ranges []Range
+}
+
+// NewTable builds a routing table. The ranges may come in any order, but one
+// of them must start at "" so that every key has an owner.
+func NewTable(ranges []Range) (*Table, error) {
+	sorted := append([]Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	if len(sorted) == 0 || sorted[0].Start != "" {
+		return nil, fmt.Errorf("routing table must have a range starting at the empty key")
+	}
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Start == sorted[i-1].Start {
+			return nil, fmt.Errorf("two ranges start at %q", sorted[i].Start)
+		}
+	}
+	return &Table{ranges: sorted}, nil
+}
+
+// SplitEvenly builds a routing table giving each node an equal run of keys,
+// which must be sorted
+func SplitEvenly(nodes []string, keys []string) *Table {
+	t := &Table{}
+	for i, n := range nodes {
+		start := ""
+		if i > 0 {
+			j := i * len(keys) / len(nodes)
+			if j >= len(keys) {
+				break
+			}
+			start = keys[j]
+		}
+		if len(t.ranges) > 0 && t.ranges[len(t.ranges)-1].Start == start {
+			// Fewer keys than nodes: the node gets nothing
+			continue
+		}
+		t.ranges = append(t.ranges, Range{Start: start, Owner: n})
+	}
+	return t
+}
+
+// Owner returns the node owning key
+func (t *Table) Owner(key string) string {
+	i := sort.Search(len(t.ranges), func(i int) bool { return t.ranges[i].Start > key })
+	return t.ranges[i-1].Owner
+}
+
+// Ranges returns the table's ranges in key order
+func (t *Table) Ranges() []Range {
+	return append([]Range(nil), t.ranges...)
+}
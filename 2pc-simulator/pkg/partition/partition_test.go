@@ -0,0 +1,113 @@
+package partition
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestRingSpreadsKeys(t *testing.T) {
+	nodes := []string{"p-0", "p-1", "p-2"}
+	r := NewRing(nodes, 0)
+
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		counts[r.Owner(fmt.Sprintf("key-%d", i))]++
+	}
+	for _, n := range nodes {
+		if counts[n] < 500 {
+			t.Errorf("Node %s owns only %d of 3000 keys", n, counts[n])
+		}
+	}
+	if r.Owner("key-1") != r.Owner("key-1") {
+		t.Error("Owner should be deterministic")
+	}
+}
+
+func TestRingBalancesSimulatorKeys(t *testing.T) {
+	// Keys named as cmd/2pc-sim names them. With 64 points per node a node's
+	// share of the ring still varies by a few tens of percent; unmixed FNV
+	// put up to twice the fair share on one node.
+	const tolerance = 0.4
+	for _, numNodes := range []int{2, 3, 5, 8} {
+		var nodes []string
+		for i := 0; i < numNodes; i++ {
+			nodes = append(nodes, fmt.Sprintf("p-%d", i))
+		}
+		r := NewRing(nodes, DefaultReplicas)
+		for _, numKeys := range []int{1000, 10000} {
+			width := len(fmt.Sprint(numKeys))
+			counts := make(map[string]int)
+			for i := 0; i < numKeys; i++ {
+				counts[r.Owner(fmt.Sprintf("key-%0*d", width, i))]++
+			}
+			fair := float64(numKeys) / float64(numNodes)
+			for _, n := range nodes {
+				if dev := math.Abs(float64(counts[n])-fair) / fair; dev > tolerance {
+					t.Errorf("%d nodes, %d keys: %s owns %d keys, %.0f%% off its fair share of %.0f", numNodes, numKeys, n, counts[n], dev*100, fair)
+				}
+			}
+		}
+	}
+}
+
+func TestRingRemoveOnlyMovesThatNodesKeys(t *testing.T) {
+	r := NewRing([]string{"p-0", "p-1", "p-2"}, 0)
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		before[k] = r.Owner(k)
+	}
+
+	r.Remove("p-2")
+	for k, owner := range before {
+		now := r.Owner(k)
+		if now == "p-2" {
+			t.Fatalf("Key %s still owned by a removed node", k)
+		}
+		if owner != "p-2" && now != owner {
+			t.Errorf("Key %s moved from %s to %s", k, owner, now)
+		}
+	}
+
+	if NewRing(nil, 0).Owner("a") != "" {
+		t.Error("An empty ring owns nothing")
+	}
+}
+
+func TestTableOwner(t *testing.T) {
+	table, err := NewTable([]Range{{Start: "m", Owner: "p-1"}, {Start: "", Owner: "p-0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"": "p-0", "apple": "p-0", "m": "p-1", "zebra": "p-1"} {
+		if got := table.Owner(key); got != want {
+			t.Errorf("Owner(%q) = %s, want %s", key, got, want)
+		}
+	}
+
+	if _, err := NewTable([]Range{{Start: "m", Owner: "p-1"}}); err == nil {
+		t.Error("Expected an error without a range starting at the empty key")
+	}
+	if _, err := NewTable([]Range{{Start: "", Owner: "p-0"}, {Start: "", Owner: "p-1"}}); err == nil {
+		t.Error("Expected an error for overlapping ranges")
+	}
+}
+
+func TestSplitEvenly(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	table := SplitEvenly([]string{"p-0", "p-1", "p-2"}, keys)
+
+	want := []string{"p-0", "p-0", "p-1", "p-1", "p-2", "p-2"}
+	for i, k := range keys {
+		if got := table.Owner(k); got != want[i] {
+			t.Errorf("Owner(%q) = %s, want %s", k, got, want[i])
+		}
+	}
+
+	// With fewer keys than nodes some nodes get nothing, but every key has an owner
+	small := SplitEvenly([]string{"p-0", "p-1", "p-2"}, []string{"a", "b"})
+	if len(small.Ranges()) != 3 || small.Owner("a") == small.Owner("b") {
+		t.Errorf("Unexpected ranges %v", small.Ranges())
+	}
+}
@@ -12,6 +12,26 @@ const (
 	MsgCommit
 	MsgAbort
 	MsgAck
+	MsgDecisionRequest
+	MsgDecisionReply
+	MsgPeerDecisionRequest
+	MsgPeerDecisionReply
+	// Three-Phase Commit
+	MsgCanCommit
+	MsgPreCommit
+	MsgPreCommitAck
+	MsgDoCommit
+	// MsgVoteReadOnly votes Yes with nothing to commit: the participant is
+	// done and takes no part in Phase 2
+	MsgVoteReadOnly
+	// MsgCommitOnePhase hands a transaction to its only participant, which
+	// runs it, decides on its own and answers with MsgOnePhaseReply
+	MsgCommitOnePhase
+	MsgOnePhaseReply
+	// MsgWound asks a transaction's coordinator to abort it, if it has not
+	// decided yet, because an older transaction under wound-wait is waiting
+	// for a lock it holds at a participant where it has already voted Yes
+	MsgWound
 )
 
 func (m MessageType) String() string {
@@ -28,6 +48,30 @@ func (m MessageType) String() string {
 		return "Abort"
 	case MsgAck:
 		return "Ack"
+	case MsgDecisionRequest:
+		return "DecisionRequest"
+	case MsgDecisionReply:
+		return "DecisionReply"
+	case MsgPeerDecisionRequest:
+		return "PeerDecisionRequest"
+	case MsgPeerDecisionReply:
+		return "PeerDecisionReply"
+	case MsgCanCommit:
+		return "CanCommit"
+	case MsgPreCommit:
+		return "PreCommit"
+	case MsgPreCommitAck:
+		return "PreCommitAck"
+	case MsgDoCommit:
+		return "DoCommit"
+	case MsgVoteReadOnly:
+		return "VoteReadOnly"
+	case MsgCommitOnePhase:
+		return "CommitOnePhase"
+	case MsgOnePhaseReply:
+		return "OnePhaseReply"
+	case MsgWound:
+		return "Wound"
 	default:
 		return "Unknown"
 	}
@@ -41,6 +85,7 @@ const (
 	StateReady
 	StateCommitted
 	StateAborted
+	StatePreCommitted // 3PC only: everyone voted Yes, commit is certain
 )
 
 func (s State) String() string {
@@ -53,16 +98,76 @@ func (s State) String() string {
 		return "Committed"
 	case StateAborted:
 		return "Aborted"
+	case StatePreCommitted:
+		return "PreCommitted"
 	default:
 		return "Unknown"
 	}
 }
 
+// OpType is the kind of work an Operation does on a key
+type OpType int
+
+const (
+	OpGet OpType = iota
+	OpPut
+	OpDelete
+	OpCompareAndSet
+)
+
+func (o OpType) String() string {
+	switch o {
+	case OpGet:
+		return "Get"
+	case OpPut:
+		return "Put"
+	case OpDelete:
+		return "Delete"
+	case OpCompareAndSet:
+		return "CompareAndSet"
+	default:
+		return "Unknown"
+	}
+}
+
+// Operation is a single read or write on a key, run by the participant that owns the key
+type Operation struct {
+	Type OpType
+	Key  string
+	// Value is the value to write, or in a vote the value a Get read
+	Value string `json:",omitempty"`
+	// Expected is what a CompareAndSet requires the current value to be (a missing key reads as "")
+	Expected string `json:",omitempty"`
+	// Found reports in a vote whether a Get found the key
+	Found bool `json:",omitempty"`
+}
+
+// IsWrite reports whether the operation changes data
+func (op Operation) IsWrite() bool {
+	return op.Type != OpGet
+}
+
 // Message represents a general 2PC message
 type Message struct {
 	Type          MessageType
 	TransactionID uuid.UUID
 	FromID        string
 	ToID          string
-	// Payload could be added here for real transactions
+	// Decision carries the outcome (MsgCommit or MsgAbort) in a DecisionReply,
+	// PeerDecisionReply or OnePhaseReply
+	Decision MessageType
+	// Participants lists everyone taking part in the transaction; sent with Prepare
+	// so participants can run the cooperative termination protocol
+	Participants []string
+	// Operations is the participant's share of the work, sent with Prepare or
+	// CommitOnePhase. Votes and OnePhaseReply carry back the results of its Gets.
+	Operations []Operation
+	// Timestamp is when the coordinator began the transaction (Unix nanoseconds),
+	// sent with Prepare or CommitOnePhase so participants can tell older
+	// transactions from younger
+	Timestamp int64
+	// OnePhase marks an Abort for a transaction sent with CommitOnePhase. The
+	// participant answers it with MsgOnePhaseReply, carrying the outcome it
+	// reached, so the coordinator learns whether it committed after all.
+	OnePhase bool
 }
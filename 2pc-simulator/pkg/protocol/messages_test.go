@@ -15,6 +15,18 @@ func TestMessageTypeString(t *testing.T) {
 		{MsgCommit, "Commit"},
 		{MsgAbort, "Abort"},
 		{MsgAck, "Ack"},
+		{MsgDecisionRequest, "DecisionRequest"},
+		{MsgDecisionReply, "DecisionReply"},
+		{MsgPeerDecisionRequest, "PeerDecisionRequest"},
+		{MsgPeerDecisionReply, "PeerDecisionReply"},
+		{MsgCanCommit, "CanCommit"},
+		{MsgPreCommit, "PreCommit"},
+		{MsgPreCommitAck, "PreCommitAck"},
+		{MsgDoCommit, "DoCommit"},
+		{MsgVoteReadOnly, "VoteReadOnly"},
+		{MsgCommitOnePhase, "CommitOnePhase"},
+		{MsgOnePhaseReply, "OnePhaseReply"},
+		{MsgWound, "Wound"},
 		{MessageType(999), "Unknown"},
 	}
 
@@ -35,6 +47,7 @@ func TestStateString(t *testing.T) {
 		{StateReady, "Ready"},
 		{StateCommitted, "Committed"},
 		{StateAborted, "Aborted"},
+		{StatePreCommitted, "PreCommitted"},
 		{State(999), "Unknown"},
 	}
 
@@ -45,3 +58,34 @@ func TestStateString(t *testing.T) {
 		}
 	}
 }
+
+func TestOpTypeString(t *testing.T) {
+	tests := []struct {
+		opType   OpType
+		expected string
+	}{
+		{OpGet, "Get"},
+		{OpPut, "Put"},
+		{OpDelete, "Delete"},
+		{OpCompareAndSet, "CompareAndSet"},
+		{OpType(999), "Unknown"},
+	}
+
+	for _, tc := range tests {
+		got := tc.opType.String()
+		if got != tc.expected {
+			t.Errorf("OpType(%d).String() = %s; want %s", tc.opType, got, tc.expected)
+		}
+	}
+}
+
+func TestOperationIsWrite(t *testing.T) {
+	if (Operation{Type: OpGet, Key: "a"}).IsWrite() {
+		t.Error("Get should not be a write")
+	}
+	for _, op := range []OpType{OpPut, OpDelete, OpCompareAndSet} {
+		if !(Operation{Type: op, Key: "a"}).IsWrite() {
+			t.Errorf("%s should be a write", op)
+		}
+	}
+}
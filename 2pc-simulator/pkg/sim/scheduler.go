@@ -0,0 +1,232 @@
+package sim
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+
+	"2pc-sim/pkg/clock"
+)
+
+// Epoch is the virtual time every Scheduler starts at
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Scheduler runs a simulation as a sequence of discrete events on a virtual
+// clock. Events run one at a time, on the goroutine that called Step or one of
+// the Run methods, and time jumps straight to the next event, so a run takes
+// as long as its computation rather than its timeouts. Events due at the same
+// instant run in an order drawn from the seed: the same seed replays the same
+// run, and other seeds explore other interleavings.
+type Scheduler struct {
+	mu      sync.Mutex
+	now     time.Time
+	events  eventQueue
+	seq     uint64
+	rng     *rand.Rand
+	pollers []*poller
+}
+
+// event is a function due at a virtual time
+type event struct {
+	at        time.Time
+	tie       int64  // random order among events due at the same time
+	seq       uint64 // then the order they were scheduled in
+	f         func()
+	index     int // position in the queue, -1 once popped or removed
+	scheduler *Scheduler
+}
+
+// poller is a message loop the scheduler runs itself, see Serve
+type poller struct {
+	stop <-chan struct{}
+	poll func() bool
+}
+
+// NewScheduler creates a scheduler at Epoch whose tie-breaking follows seed
+func NewScheduler(seed int64) *Scheduler {
+	return &Scheduler{now: Epoch, rng: rand.New(rand.NewSource(seed))}
+}
+
+var _ clock.Clock = (*Scheduler)(nil)
+
+// Now returns the virtual time
+func (s *Scheduler) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+func (s *Scheduler) Since(t time.Time) time.Duration {
+	return s.Now().Sub(t)
+}
+
+// AfterFunc schedules f to run d after the current virtual time
+func (s *Scheduler) AfterFunc(d time.Duration, f func()) clock.Timer {
+	if d < 0 {
+		d = 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	e := &event{at: s.now.Add(d), tie: s.rng.Int63(), seq: s.seq, f: f, scheduler: s}
+	heap.Push(&s.events, e)
+	return e
+}
+
+// Stop removes the event from the queue
+func (e *event) Stop() bool {
+	s := e.scheduler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.index < 0 {
+		return false
+	}
+	heap.Remove(&s.events, e.index)
+	return true
+}
+
+// Serve registers a message loop. After every event, poll is called until it
+// reports it found nothing to do, which lets nodes handle the messages an
+// event delivered to their inbox on the scheduler's goroutine. The loop is
+// dropped once stop is closed.
+func (s *Scheduler) Serve(stop <-chan struct{}, poll func() bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pollers = append(s.pollers, &poller{stop: stop, poll: poll})
+}
+
+// Pending returns the number of events waiting to run
+func (s *Scheduler) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.events)
+}
+
+// Step runs the next event and every message loop it woke up. It reports
+// false when there was nothing left to run.
+func (s *Scheduler) Step() bool {
+	s.mu.Lock()
+	if len(s.events) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	e := heap.Pop(&s.events).(*event)
+	s.now = e.at
+	s.mu.Unlock()
+
+	e.f()
+	s.drain()
+	return true
+}
+
+// drain runs the message loops, in the order they were registered, until none
+// of them has anything left to do
+func (s *Scheduler) drain() {
+	for {
+		s.mu.Lock()
+		live := s.pollers[:0]
+		for _, p := range s.pollers {
+			select {
+			case <-p.stop:
+			default:
+				live = append(live, p)
+			}
+		}
+		s.pollers = live
+		pollers := append([]*poller(nil), live...)
+		s.mu.Unlock()
+
+		progress := false
+		for _, p := range pollers {
+			select {
+			case <-p.stop:
+				continue
+			default:
+			}
+			for p.poll() {
+				progress = true
+			}
+		}
+		if !progress {
+			return
+		}
+	}
+}
+
+// Run runs events until there are none left
+func (s *Scheduler) Run() {
+	s.drain()
+	for s.Step() {
+	}
+}
+
+// RunUntil runs events until done reports true, which it checks after every
+// event. It reports false if the events ran out first.
+func (s *Scheduler) RunUntil(done func() bool) bool {
+	s.drain()
+	for !done() {
+		if !s.Step() {
+			return false
+		}
+	}
+	return true
+}
+
+// RunFor runs every event due within d and then moves the clock to the end of
+// that window
+func (s *Scheduler) RunFor(d time.Duration) {
+	s.drain()
+	end := s.Now().Add(d)
+	for {
+		s.mu.Lock()
+		due := len(s.events) > 0 && !s.events[0].at.After(end)
+		s.mu.Unlock()
+		if !due {
+			break
+		}
+		s.Step()
+	}
+	s.mu.Lock()
+	if s.now.Before(end) {
+		s.now = end
+	}
+	s.mu.Unlock()
+}
+
+// eventQueue is a min-heap of events ordered by time, then tie, then seq
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	a, b := q[i], q[j]
+	if !a.at.Equal(b.at) {
+		return a.at.Before(b.at)
+	}
+	if a.tie != b.tie {
+		return a.tie < b.tie
+	}
+	return a.seq < b.seq
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x any) {
+	e := x.(*event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*q = old[:len(old)-1]
+	return e
+}
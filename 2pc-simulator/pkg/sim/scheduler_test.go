@@ -0,0 +1,125 @@
+package sim
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRunsEventsInTimeOrder(t *testing.T) {
+	s := NewScheduler(1)
+	var order []string
+	s.AfterFunc(30*time.Millisecond, func() { order = append(order, "c") })
+	s.AfterFunc(10*time.Millisecond, func() {
+		order = append(order, "a")
+		// Scheduled from an event, relative to its time
+		s.AfterFunc(5*time.Millisecond, func() { order = append(order, "b") })
+	})
+	s.Run()
+
+	if fmt.Sprint(order) != "[a b c]" {
+		t.Errorf("Expected [a b c], got %v", order)
+	}
+	if got := s.Since(Epoch); got != 30*time.Millisecond {
+		t.Errorf("Expected the clock at 30ms, got %v", got)
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	s := NewScheduler(1)
+	ran := false
+	timer := s.AfterFunc(time.Second, func() { ran = true })
+	if !timer.Stop() {
+		t.Error("Stopping a pending event should succeed")
+	}
+	if timer.Stop() {
+		t.Error("Stopping it twice should fail")
+	}
+	s.Run()
+	if ran {
+		t.Error("A stopped event should not run")
+	}
+	if s.Now() != Epoch {
+		t.Error("A stopped event should not move the clock")
+	}
+}
+
+func TestSchedulerSeedOrdersTies(t *testing.T) {
+	order := func(seed int64) string {
+		s := NewScheduler(seed)
+		out := ""
+		for i := 0; i < 8; i++ {
+			s.AfterFunc(time.Millisecond, func() { out += fmt.Sprint(i) })
+		}
+		s.Run()
+		return out
+	}
+
+	if order(3) != order(3) {
+		t.Error("The same seed should break ties the same way")
+	}
+	seen := make(map[string]bool)
+	for seed := int64(0); seed < 10; seed++ {
+		seen[order(seed)] = true
+	}
+	if len(seen) < 2 {
+		t.Error("Different seeds should break ties differently")
+	}
+}
+
+func TestSchedulerServe(t *testing.T) {
+	s := NewScheduler(1)
+	inbox := make(chan int, 10)
+	stop := make(chan struct{})
+	var got []int
+	s.Serve(stop, func() bool {
+		select {
+		case v := <-inbox:
+			got = append(got, v)
+			return true
+		default:
+			return false
+		}
+	})
+
+	s.AfterFunc(time.Millisecond, func() { inbox <- 1; inbox <- 2 })
+	s.Step()
+	if fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("Messages should be handled right after the event that sent them, got %v", got)
+	}
+
+	close(stop)
+	s.AfterFunc(time.Millisecond, func() { inbox <- 3 })
+	s.Run()
+	if len(got) != 2 {
+		t.Errorf("A stopped loop should not be polled, got %v", got)
+	}
+}
+
+func TestSchedulerRunUntilAndRunFor(t *testing.T) {
+	s := NewScheduler(1)
+	count := 0
+	var tick func()
+	tick = func() {
+		count++
+		s.AfterFunc(10*time.Millisecond, tick)
+	}
+	s.AfterFunc(10*time.Millisecond, tick)
+
+	if !s.RunUntil(func() bool { return count == 3 }) {
+		t.Fatal("RunUntil should stop once done")
+	}
+	if s.Since(Epoch) != 30*time.Millisecond {
+		t.Errorf("Expected the clock at 30ms, got %v", s.Since(Epoch))
+	}
+
+	s.RunFor(25 * time.Millisecond)
+	if count != 5 || s.Since(Epoch) != 55*time.Millisecond {
+		t.Errorf("Expected 5 ticks at 55ms, got %d at %v", count, s.Since(Epoch))
+	}
+
+	empty := NewScheduler(1)
+	if empty.RunUntil(func() bool { return false }) {
+		t.Error("RunUntil should report running out of events")
+	}
+}
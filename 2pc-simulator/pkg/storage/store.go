@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Write is a single buffered change to a key
+type Write struct {
+	Key    string
+	Value  string `json:",omitempty"`
+	Delete bool   `json:",omitempty"`
+}
+
+// Store is an in-memory key-value store. Writes made by a transaction are
+// buffered in its write set and only become visible when it commits.
+type Store struct {
+	mu      sync.Mutex
+	data    map[string]string
+	pending map[uuid.UUID][]Write // write set of each open transaction
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{
+		data:    make(map[string]string),
+		pending: make(map[uuid.UUID][]Write),
+	}
+}
+
+// Get returns the committed value of key
+func (s *Store) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// Read returns key as seen by a transaction: its own writes first, then committed data
+func (s *Store) Read(txID uuid.UUID, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writes := s.pending[txID]
+	for i := len(writes) - 1; i >= 0; i-- {
+		if writes[i].Key == key {
+			return writes[i].Value, !writes[i].Delete
+		}
+	}
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// Put adds a write of key to the transaction's write set
+func (s *Store) Put(txID uuid.UUID, key, value string) {
+	s.Stage(txID, []Write{{Key: key, Value: value}})
+}
+
+// Delete adds a removal of key to the transaction's write set
+func (s *Store) Delete(txID uuid.UUID, key string) {
+	s.Stage(txID, []Write{{Key: key, Delete: true}})
+}
+
+// Stage appends writes to the transaction's write set
+func (s *Store) Stage(txID uuid.UUID, writes []Write) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[txID] = append(s.pending[txID], writes...)
+}
+
+// Writes returns the transaction's write set, oldest first
+func (s *Store) Writes(txID uuid.UUID) []Write {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Write(nil), s.pending[txID]...)
+}
+
+// Commit applies the transaction's write set and forgets it
+func (s *Store) Commit(txID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apply(s.pending[txID])
+	delete(s.pending, txID)
+}
+
+// Abort discards the transaction's write set
+func (s *Store) Abort(txID uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, txID)
+}
+
+// Apply writes changes straight to committed data, as when redoing a
+// committed transaction from the log
+func (s *Store) Apply(writes []Write) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apply(writes)
+}
+
+func (s *Store) apply(writes []Write) {
+	for _, w := range writes {
+		if w.Delete {
+			delete(s.data, w.Key)
+		} else {
+			s.data[w.Key] = w.Value
+		}
+	}
+}
+
+// Reset throws everything away, as a crash does to memory
+func (s *Store) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = make(map[string]string)
+	s.pending = make(map[uuid.UUID][]Write)
+}
+
+// Len returns the number of committed keys
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data)
+}
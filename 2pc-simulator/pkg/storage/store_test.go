@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestStoreCommit(t *testing.T) {
+	s := NewStore()
+	txID := uuid.New()
+
+	s.Put(txID, "a", "1")
+	s.Put(txID, "b", "2")
+	s.Delete(txID, "b")
+
+	if _, ok := s.Get("a"); ok {
+		t.Error("Uncommitted write should not be visible")
+	}
+	if v, ok := s.Read(txID, "a"); !ok || v != "1" {
+		t.Errorf("Transaction should read its own write, got %q", v)
+	}
+	if _, ok := s.Read(txID, "b"); ok {
+		t.Error("Transaction should see its own delete")
+	}
+
+	s.Commit(txID)
+	if v, ok := s.Get("a"); !ok || v != "1" {
+		t.Errorf("Expected a=1 after commit, got %q", v)
+	}
+	if _, ok := s.Get("b"); ok {
+		t.Error("Expected b to be deleted after commit")
+	}
+	if len(s.Writes(txID)) != 0 {
+		t.Error("Commit should clear the write set")
+	}
+}
+
+func TestStoreAbort(t *testing.T) {
+	s := NewStore()
+	s.Apply([]Write{{Key: "a", Value: "old"}})
+
+	txID := uuid.New()
+	s.Put(txID, "a", "new")
+	s.Abort(txID)
+
+	if v, _ := s.Get("a"); v != "old" {
+		t.Errorf("Abort should discard writes, got a=%q", v)
+	}
+	s.Commit(txID)
+	if v, _ := s.Get("a"); v != "old" {
+		t.Errorf("Committing an aborted transaction should change nothing, got a=%q", v)
+	}
+}
+
+func TestStoreReset(t *testing.T) {
+	s := NewStore()
+	txID := uuid.New()
+	s.Apply([]Write{{Key: "a", Value: "1"}})
+	s.Put(txID, "b", "2")
+
+	s.Reset()
+	if s.Len() != 0 || len(s.Writes(txID)) != 0 {
+		t.Error("Reset should clear committed data and write sets")
+	}
+}
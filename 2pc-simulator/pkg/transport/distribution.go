@@ -0,0 +1,217 @@
+package transport
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Distribution draws the delay of a message on a link whose average latency
+// is mean
+type Distribution interface {
+	Sample(r *rand.Rand, mean time.Duration) time.Duration
+	String() string
+}
+
+// Uniform spreads delays evenly over mean +/- Jitter*mean
+type Uniform struct {
+	Jitter float64
+}
+
+func (d Uniform) Sample(r *rand.Rand, mean time.Duration) time.Duration {
+	jitterRange := float64(mean) * d.Jitter
+	// r.Float64 returns [0.0, 1.0), so the offset is in [-jitterRange, +jitterRange)
+	offset := (r.Float64() * 2 * jitterRange) - jitterRange
+	return time.Duration(float64(mean) + offset)
+}
+
+func (d Uniform) String() string {
+	return fmt.Sprintf("uniform:%g", d.Jitter)
+}
+
+// Exponential is memoryless: most messages are quick, a few take several
+// times the mean
+type Exponential struct{}
+
+func (Exponential) Sample(r *rand.Rand, mean time.Duration) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(mean))
+}
+
+func (Exponential) String() string {
+	return "exponential"
+}
+
+// Normal is a bell curve around the mean with a standard deviation of
+// StdDev*mean; draws below zero are cut to zero
+type Normal struct {
+	StdDev float64
+}
+
+func (d Normal) Sample(r *rand.Rand, mean time.Duration) time.Duration {
+	return time.Duration(float64(mean) * (1 + d.StdDev*r.NormFloat64()))
+}
+
+func (d Normal) String() string {
+	return fmt.Sprintf("normal:%g", d.StdDev)
+}
+
+// LogNormal is skewed to the right, as measured network latencies usually
+// are. Sigma is the standard deviation of the delay's logarithm; the mean of
+// the delays stays the link's latency.
+type LogNormal struct {
+	Sigma float64
+}
+
+func (d LogNormal) Sample(r *rand.Rand, mean time.Duration) time.Duration {
+	mu := math.Log(float64(mean)) - d.Sigma*d.Sigma/2
+	return time.Duration(math.Exp(mu + d.Sigma*r.NormFloat64()))
+}
+
+func (d LogNormal) String() string {
+	return fmt.Sprintf("lognormal:%g", d.Sigma)
+}
+
+// Pareto has a long tail: the smaller Alpha, the more often a message takes
+// far longer than the mean. Alpha must be above 1 for the mean to exist; the
+// minimum delay is set so that it is the link's latency.
+type Pareto struct {
+	Alpha float64
+}
+
+func (d Pareto) Sample(r *rand.Rand, mean time.Duration) time.Duration {
+	scale := float64(mean) * (d.Alpha - 1) / d.Alpha
+	// 1 - r.Float64() is in (0, 1], which keeps the draw finite
+	return time.Duration(scale / math.Pow(1-r.Float64(), 1/d.Alpha))
+}
+
+func (d Pareto) String() string {
+	return fmt.Sprintf("pareto:%g", d.Alpha)
+}
+
+// Empirical replays a measured histogram of delays. It ignores the link's
+// latency: the histogram's buckets give the delays themselves.
+type Empirical struct {
+	path   string
+	bounds []time.Duration // upper bound of each bucket, ascending
+	cum    []float64       // running total of the bucket counts
+}
+
+// LoadEmpirical reads a histogram file. Each line is a bucket: the upper bound
+// of its delays in milliseconds and how many were measured, separated by
+// spaces or a comma. A bucket starts where the previous one ends, or at 0.
+// Blank lines and lines starting with # are skipped.
+func LoadEmpirical(path string) (*Empirical, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := &Empirical{path: path}
+	total := 0.0
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.ReplaceAll(text, ",", " "))
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want <upper bound ms> <count>", path, line)
+		}
+		bound, err1 := strconv.ParseFloat(fields[0], 64)
+		count, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil || bound < 0 || count < 0 {
+			return nil, fmt.Errorf("%s:%d: bad bucket %q", path, line, text)
+		}
+		upper := time.Duration(bound * float64(time.Millisecond))
+		if n := len(d.bounds); n > 0 && upper <= d.bounds[n-1] {
+			return nil, fmt.Errorf("%s:%d: bucket bounds must increase", path, line)
+		}
+		total += count
+		d.bounds = append(d.bounds, upper)
+		d.cum = append(d.cum, total)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%s: histogram is empty", path)
+	}
+	return d, nil
+}
+
+func (d *Empirical) Sample(r *rand.Rand, mean time.Duration) time.Duration {
+	// Pick a bucket by its count, then a delay evenly within it
+	u := r.Float64() * d.cum[len(d.cum)-1]
+	i := sort.Search(len(d.cum), func(i int) bool { return d.cum[i] > u })
+	lower := time.Duration(0)
+	if i > 0 {
+		lower = d.bounds[i-1]
+	}
+	return lower + time.Duration(r.Float64()*float64(d.bounds[i]-lower))
+}
+
+func (d *Empirical) String() string {
+	return "empirical:" + d.path
+}
+
+// ParseDistribution reads a distribution given as name[:parameter]: uniform
+// and normal take a spread relative to the mean (jitter when left out),
+// lognormal a sigma (0.5), pareto an alpha above 1 (2.5), and empirical the
+// path of a histogram file
+func ParseDistribution(spec string, jitter float64) (Distribution, error) {
+	name, param, hasParam := strings.Cut(spec, ":")
+	if name == "empirical" {
+		if param == "" {
+			return nil, fmt.Errorf("distribution %q: empirical needs a histogram file", spec)
+		}
+		return LoadEmpirical(param)
+	}
+
+	v := 0.0
+	if hasParam {
+		var err error
+		if v, err = strconv.ParseFloat(param, 64); err != nil || v < 0 {
+			return nil, fmt.Errorf("distribution %q: bad parameter %q", spec, param)
+		}
+	}
+	switch name {
+	case "", "uniform":
+		if !hasParam {
+			v = jitter
+		}
+		return Uniform{Jitter: v}, nil
+	case "exponential":
+		if hasParam {
+			return nil, fmt.Errorf("distribution %q: exponential takes no parameter", spec)
+		}
+		return Exponential{}, nil
+	case "normal":
+		if !hasParam {
+			v = jitter
+		}
+		return Normal{StdDev: v}, nil
+	case "lognormal":
+		if !hasParam {
+			v = 0.5
+		}
+		return LogNormal{Sigma: v}, nil
+	case "pareto":
+		if !hasParam {
+			v = 2.5
+		}
+		if v <= 1 {
+			return nil, fmt.Errorf("distribution %q: pareto needs an alpha above 1", spec)
+		}
+		return Pareto{Alpha: v}, nil
+	default:
+		return nil, fmt.Errorf("unknown distribution %q (want uniform, exponential, normal, lognormal, pareto or empirical)", name)
+	}
+}
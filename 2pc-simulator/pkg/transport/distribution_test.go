@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDistributionsKeepTheMean(t *testing.T) {
+	const mean = 10 * time.Millisecond
+	for _, d := range []Distribution{Uniform{Jitter: 0.5}, Exponential{}, Normal{StdDev: 0.2}, LogNormal{Sigma: 0.5}, Pareto{Alpha: 3}} {
+		r := rand.New(rand.NewSource(1))
+		var sum time.Duration
+		const n = 100000
+		for i := 0; i < n; i++ {
+			sum += d.Sample(r, mean)
+		}
+		if got := sum / n; math.Abs(float64(got-mean)) > 0.03*float64(mean) {
+			t.Errorf("%s: expected a mean near %v, got %v", d, mean, got)
+		}
+	}
+}
+
+func TestDistributionShapes(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const mean = 10 * time.Millisecond
+	for i := 0; i < 10000; i++ {
+		if d := (Uniform{Jitter: 0.2}).Sample(r, mean); d < 8*time.Millisecond || d >= 12*time.Millisecond {
+			t.Fatalf("Uniform draw %v outside mean +/- 20%%", d)
+		}
+		if d := (Pareto{Alpha: 2}).Sample(r, mean); d < 5*time.Millisecond {
+			t.Fatalf("Pareto draw %v below its minimum", d)
+		}
+	}
+}
+
+func TestEmpirical(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "histogram.txt")
+	// Nothing up to 2ms, three quarters up to 5ms and the rest up to 100ms
+	if err := os.WriteFile(path, []byte("# ms count\n2, 0\n5 75\n\n100 25\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := LoadEmpirical(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	fast := 0
+	const n = 10000
+	for i := 0; i < n; i++ {
+		s := d.Sample(r, time.Hour)
+		if s < 2*time.Millisecond || s >= 100*time.Millisecond {
+			t.Fatalf("Draw %v outside the histogram's buckets", s)
+		}
+		if s < 5*time.Millisecond {
+			fast++
+		}
+	}
+	if frac := float64(fast) / n; frac < 0.73 || frac > 0.77 {
+		t.Errorf("Expected 75%% of draws in the 2-5ms bucket, got %.1f%%", frac*100)
+	}
+
+	for _, bad := range []string{"5 1\n2 1\n", "5\n", "0 0\n", "x 1\n"} {
+		os.WriteFile(path, []byte(bad), 0o644)
+		if _, err := LoadEmpirical(path); err == nil {
+			t.Errorf("Expected an error for histogram %q", bad)
+		}
+	}
+}
+
+func TestParseDistribution(t *testing.T) {
+	for spec, want := range map[string]Distribution{
+		"":            Uniform{Jitter: 0.2},
+		"uniform:0.5": Uniform{Jitter: 0.5},
+		"exponential": Exponential{},
+		"normal":      Normal{StdDev: 0.2},
+		"lognormal":   LogNormal{Sigma: 0.5},
+		"pareto:1.5":  Pareto{Alpha: 1.5},
+	} {
+		got, err := ParseDistribution(spec, 0.2)
+		if err != nil || got != want {
+			t.Errorf("ParseDistribution(%q) = %v, %v; want %v", spec, got, err, want)
+		}
+	}
+	for _, bad := range []string{"gamma", "normal:-1", "pareto:1", "exponential:2", "empirical:", "empirical:/no/such/file"} {
+		if _, err := ParseDistribution(bad, 0.2); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
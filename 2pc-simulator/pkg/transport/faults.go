@@ -0,0 +1,232 @@
+package transport
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Block cuts the directed link from -> to; messages sent over it, or in
+// flight on it, are lost until it is healed
+func (n *SimulatedNetwork) Block(from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.blocked[link{from, to}] = true
+}
+
+// Heal restores the directed link from -> to
+func (n *SimulatedNetwork) Heal(from, to string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.blocked, link{from, to})
+}
+
+// Partition splits the network: nodes in different groups can no longer reach
+// each other in either direction. Nodes in no group are left alone.
+func (n *SimulatedNetwork) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, g := range groups {
+		for j, other := range groups {
+			if i == j {
+				continue
+			}
+			for _, from := range g {
+				for _, to := range other {
+					n.blocked[link{from, to}] = true
+				}
+			}
+		}
+	}
+}
+
+// Isolate cuts id off from every other node, including ones that register later
+func (n *SimulatedNetwork) Isolate(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.isolated[id] = true
+}
+
+// HealAll restores every link cut by Block, Partition or Isolate
+func (n *SimulatedNetwork) HealAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.blocked = make(map[link]bool)
+	n.isolated = make(map[string]bool)
+}
+
+// Reachable reports whether a message from -> to would get through the links,
+// leaving random drops aside
+func (n *SimulatedNetwork) Reachable(from, to string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.reachable(from, to)
+}
+
+// reachable is Reachable for callers holding n.mu
+func (n *SimulatedNetwork) reachable(from, to string) bool {
+	if from == to {
+		return true
+	}
+	return !n.isolated[from] && !n.isolated[to] && !n.blocked[link{from, to}]
+}
+
+// FaultAction is what a scheduled Fault does to the network's links
+type FaultAction int
+
+const (
+	FaultPartition FaultAction = iota // Split the nodes into Groups
+	FaultIsolate                      // Cut From off from everyone
+	FaultBlock                        // Cut the link From -> To
+	FaultHeal                         // Restore From -> To, or every link if From is empty
+)
+
+func (a FaultAction) String() string {
+	switch a {
+	case FaultPartition:
+		return "partition"
+	case FaultIsolate:
+		return "isolate"
+	case FaultBlock:
+		return "block"
+	case FaultHeal:
+		return "heal"
+	default:
+		return "unknown"
+	}
+}
+
+// Fault is a change to the network's links At a point in the run
+type Fault struct {
+	At     time.Duration
+	Action FaultAction
+	Groups [][]string // For FaultPartition
+	From   string
+	To     string
+}
+
+func (f Fault) String() string {
+	s := fmt.Sprintf("%v %s", f.At, f.Action)
+	switch {
+	case f.Action == FaultPartition:
+		var groups []string
+		for _, g := range f.Groups {
+			groups = append(groups, strings.Join(g, ","))
+		}
+		s += " " + strings.Join(groups, "|")
+	case f.Action == FaultIsolate:
+		s += " " + f.From
+	case f.From != "":
+		s += " " + f.From + ">" + f.To
+	}
+	return s
+}
+
+// Apply makes the fault's change now
+func (n *SimulatedNetwork) Apply(f Fault) {
+	log.Printf("[Network] FAULT %s", f)
+	switch f.Action {
+	case FaultPartition:
+		n.Partition(f.Groups...)
+	case FaultIsolate:
+		n.Isolate(f.From)
+	case FaultBlock:
+		n.Block(f.From, f.To)
+	case FaultHeal:
+		if f.From == "" {
+			n.HealAll()
+		} else {
+			n.Heal(f.From, f.To)
+		}
+	}
+}
+
+// Schedule applies each fault once its At has elapsed on the network's clock.
+// Every node a fault names must be registered by now; if one is not, nothing
+// is scheduled.
+func (n *SimulatedNetwork) Schedule(faults []Fault) error {
+	n.mu.RLock()
+	for _, f := range faults {
+		names := []string{f.From, f.To}
+		for _, g := range f.Groups {
+			names = append(names, g...)
+		}
+		for _, id := range names {
+			if _, ok := n.nodes[id]; id != "" && !ok {
+				n.mu.RUnlock()
+				return fmt.Errorf("fault %q: unknown node %q", f, id)
+			}
+		}
+	}
+	n.mu.RUnlock()
+
+	for _, f := range faults {
+		n.Clock.AfterFunc(f.At, func() { n.Apply(f) })
+	}
+	return nil
+}
+
+// ParseFaults reads a fault schedule: entries separated by ';', each a delay
+// from the start of the run and an action, e.g.
+//
+//	15ms isolate coordinator; 200ms partition coordinator,p-0|p-1,p-2;
+//	1s block p-0>p-1; 2s heal p-0>p-1; 3s heal
+func ParseFaults(spec string) ([]Fault, error) {
+	var faults []Fault
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("fault %q: want <delay> <action> [<nodes>]", strings.TrimSpace(entry))
+		}
+		at, err := time.ParseDuration(fields[0])
+		if err != nil || at < 0 {
+			return nil, fmt.Errorf("fault %q: bad delay %q", strings.TrimSpace(entry), fields[0])
+		}
+		f := Fault{At: at}
+		arg := ""
+		if len(fields) == 3 {
+			arg = fields[2]
+		}
+
+		switch fields[1] {
+		case "partition":
+			f.Action = FaultPartition
+			for _, g := range strings.Split(arg, "|") {
+				if g == "" {
+					return nil, fmt.Errorf("fault %q: empty group", strings.TrimSpace(entry))
+				}
+				f.Groups = append(f.Groups, strings.Split(g, ","))
+			}
+			if len(f.Groups) < 2 {
+				return nil, fmt.Errorf("fault %q: a partition needs at least two groups", strings.TrimSpace(entry))
+			}
+		case "isolate":
+			f.Action = FaultIsolate
+			if arg == "" {
+				return nil, fmt.Errorf("fault %q: isolate needs a node", strings.TrimSpace(entry))
+			}
+			f.From = arg
+		case "block", "heal":
+			f.Action = FaultBlock
+			if fields[1] == "heal" {
+				f.Action = FaultHeal
+				if arg == "" {
+					break
+				}
+			}
+			from, to, ok := strings.Cut(arg, ">")
+			if !ok || from == "" || to == "" {
+				return nil, fmt.Errorf("fault %q: want a link as <from>><to>", strings.TrimSpace(entry))
+			}
+			f.From, f.To = from, to
+		default:
+			return nil, fmt.Errorf("fault %q: unknown action %q (want partition, isolate, block or heal)", strings.TrimSpace(entry), fields[1])
+		}
+		faults = append(faults, f)
+	}
+	return faults, nil
+}
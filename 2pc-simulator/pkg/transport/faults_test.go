@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/protocol"
+)
+
+func TestPartitionAndHeal(t *testing.T) {
+	net := NewSimulatedNetwork(0, 0, 0)
+	net.Partition([]string{"coord", "p1"}, []string{"p2", "p3"})
+
+	for _, l := range []struct {
+		from, to string
+		want     bool
+	}{
+		{"coord", "p1", true},
+		{"p2", "p3", true},
+		{"coord", "p2", false},
+		{"p3", "p1", false},
+		{"coord", "outsider", true},
+	} {
+		if got := net.Reachable(l.from, l.to); got != l.want {
+			t.Errorf("Reachable(%s, %s) = %v, want %v", l.from, l.to, got, l.want)
+		}
+	}
+
+	net.HealAll()
+	if !net.Reachable("coord", "p2") {
+		t.Error("HealAll should restore every link")
+	}
+}
+
+func TestBlockIsDirected(t *testing.T) {
+	net := NewSimulatedNetwork(0, 0, 0)
+	net.Block("p1", "p2")
+	if net.Reachable("p1", "p2") || !net.Reachable("p2", "p1") {
+		t.Error("Block should only cut one direction")
+	}
+	net.Heal("p1", "p2")
+	if !net.Reachable("p1", "p2") {
+		t.Error("Heal should restore the link")
+	}
+
+	net.Isolate("p1")
+	if net.Reachable("p1", "p9") || net.Reachable("p9", "p1") {
+		t.Error("An isolated node should reach nobody")
+	}
+}
+
+func TestCutLinkLosesMessages(t *testing.T) {
+	net := NewSimulatedNetwork(10*time.Millisecond, 0, 0)
+	clk := clock.NewFake(time.Time{})
+	net.Clock = clk
+	ch := make(chan protocol.Message, 2)
+	net.Register("p1", ch)
+
+	// One message is sent over a cut link, the other is cut off in flight
+	net.Block("coord", "p1")
+	net.Send(protocol.Message{Type: protocol.MsgPrepare, FromID: "coord", ToID: "p1"})
+	net.Heal("coord", "p1")
+	net.Send(protocol.Message{Type: protocol.MsgPrepare, FromID: "coord", ToID: "p1"})
+	clk.Advance(5 * time.Millisecond)
+	net.Isolate("p1")
+	clk.Advance(5 * time.Millisecond)
+
+	if len(ch) != 0 {
+		t.Fatal("No message should get through")
+	}
+	if stats := net.Stats(); stats.Blocked != 2 || stats.Dropped != 0 {
+		t.Errorf("Expected 2 blocked and no random drops, got %+v", stats)
+	}
+}
+
+func TestScheduleFaults(t *testing.T) {
+	faults, err := ParseFaults("15ms isolate coord; 1s heal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	net := NewSimulatedNetwork(0, 0, 0)
+	clk := clock.NewFake(time.Time{})
+	net.Clock = clk
+	net.Register("coord", make(chan protocol.Message, 1))
+	if err := net.Schedule(faults); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(14 * time.Millisecond)
+	if !net.Reachable("coord", "p1") {
+		t.Error("The fault should not apply before its time")
+	}
+	clk.Advance(time.Millisecond)
+	if net.Reachable("coord", "p1") {
+		t.Error("The coordinator should be isolated at 15ms")
+	}
+	clk.Advance(time.Second)
+	if !net.Reachable("coord", "p1") {
+		t.Error("The network should heal at 1s")
+	}
+}
+
+func TestScheduleRejectsUnknownNodes(t *testing.T) {
+	net := NewSimulatedNetwork(0, 0, 0)
+	clk := clock.NewFake(time.Time{})
+	net.Clock = clk
+	net.Register("coord", make(chan protocol.Message, 1))
+	net.Register("p1", make(chan protocol.Message, 1))
+
+	for _, spec := range []string{
+		"10ms isolate cord",
+		"10ms partition coord|p1,p2",
+		"10ms block coord>p9",
+		"10ms heal p9>coord",
+	} {
+		faults, err := ParseFaults(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The good fault in front of the bad one is not scheduled either
+		faults = append([]Fault{{At: 5 * time.Millisecond, Action: FaultIsolate, From: "p1"}}, faults...)
+		if err := net.Schedule(faults); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+	clk.Advance(time.Second)
+	if !net.Reachable("coord", "p1") {
+		t.Error("No fault should apply once the schedule is rejected")
+	}
+}
+
+func TestParseFaults(t *testing.T) {
+	spec := "0s partition coord,p1|p2; 2s block p1>p2;3s heal p1>p2; 4s heal;"
+	faults, err := ParseFaults(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"0s partition coord,p1|p2", "2s block p1>p2", "3s heal p1>p2", "4s heal"}
+	if len(faults) != len(want) {
+		t.Fatalf("Expected %d faults, got %v", len(want), faults)
+	}
+	for i, f := range faults {
+		if f.String() != want[i] {
+			t.Errorf("Fault %d: expected %q, got %q", i, want[i], f)
+		}
+	}
+
+	for _, bad := range []string{"soon heal", "1s reboot p1", "1s partition p1", "1s block p1", "1s isolate", "1s heal p1>p2 extra"} {
+		if _, err := ParseFaults(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
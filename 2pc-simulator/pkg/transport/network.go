@@ -6,6 +6,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
 	"2pc-sim/pkg/protocol"
 )
 
@@ -23,7 +26,41 @@ type SimulatedNetwork struct {
 	AverageDelay time.Duration
 	DropRate     float64 // 0.0 to 1.0 (0% to 100% loss)
 	Jitter       float64 // 0.0 to 1.0 (relative to AverageDelay)
-	r            *rand.Rand
+	// Dist draws message delays around AverageDelay; nil is Uniform with Jitter
+	Dist Distribution
+	// Clock times message delivery; a virtual one makes delays cost nothing
+	Clock    clock.Clock
+	r        *rand.Rand
+	stats    Stats
+	blocked  map[link]bool   // directed links that are cut
+	isolated map[string]bool // nodes cut off from everyone
+	links    map[link]LinkConfig
+}
+
+// LinkConfig is the latency, jitter and loss of one directed link
+type LinkConfig struct {
+	Delay    time.Duration
+	Jitter   float64 // 0.0 to 1.0 (relative to Delay)
+	DropRate float64 // 0.0 to 1.0
+	// Dist draws delays around Delay; nil is Uniform with Jitter
+	Dist Distribution
+}
+
+// link is a direction of travel between two nodes
+type link struct {
+	from, to string
+}
+
+// Stats counts the traffic a SimulatedNetwork has carried
+type Stats struct {
+	Sent    int
+	Dropped int
+	// Blocked counts the messages lost to a cut link, on sending or in flight
+	Blocked int
+	ByType  map[protocol.MessageType]int
+	// DroppedByTx counts the drops suffered by each transaction, blocked
+	// messages included
+	DroppedByTx map[uuid.UUID]int
 }
 
 // NewSimulatedNetwork creates a new simulated network
@@ -33,10 +70,46 @@ func NewSimulatedNetwork(delay time.Duration, dropRate float64, jitter float64)
 		AverageDelay: delay,
 		DropRate:     dropRate,
 		Jitter:       jitter,
+		Clock:        clock.Real{},
 		r:            rand.New(rand.NewSource(time.Now().UnixNano())),
+		stats:        Stats{ByType: make(map[protocol.MessageType]int), DroppedByTx: make(map[uuid.UUID]int)},
+		blocked:      make(map[link]bool),
+		isolated:     make(map[string]bool),
+		links:        make(map[link]LinkConfig),
 	}
 }
 
+// SetLink gives the directed link from -> to its own settings in place of the
+// network-wide AverageDelay, Jitter and DropRate
+func (n *SimulatedNetwork) SetLink(from, to string, cfg LinkConfig) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.links[link{from, to}] = cfg
+}
+
+// Link returns the settings messages from -> to travel with
+func (n *SimulatedNetwork) Link(from, to string) LinkConfig {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.link(from, to)
+}
+
+// link is Link for callers holding n.mu
+func (n *SimulatedNetwork) link(from, to string) LinkConfig {
+	if cfg, ok := n.links[link{from, to}]; ok {
+		return cfg
+	}
+	return LinkConfig{Delay: n.AverageDelay, Jitter: n.Jitter, DropRate: n.DropRate, Dist: n.Dist}
+}
+
+// Seed restarts the network's drops and delays from seed, so that they can be
+// replayed
+func (n *SimulatedNetwork) Seed(seed int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.r = rand.New(rand.NewSource(seed))
+}
+
 func (n *SimulatedNetwork) Register(id string, ch chan protocol.Message) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
@@ -49,18 +122,52 @@ func (n *SimulatedNetwork) Unregister(id string) {
 	delete(n.nodes, id)
 }
 
+// Stats returns a snapshot of the traffic counters
+func (n *SimulatedNetwork) Stats() Stats {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	out := Stats{
+		Sent:        n.stats.Sent,
+		Dropped:     n.stats.Dropped,
+		Blocked:     n.stats.Blocked,
+		ByType:      make(map[protocol.MessageType]int),
+		DroppedByTx: make(map[uuid.UUID]int),
+	}
+	for t, c := range n.stats.ByType {
+		out.ByType[t] = c
+	}
+	for tx, c := range n.stats.DroppedByTx {
+		out.DroppedByTx[tx] = c
+	}
+	return out
+}
+
 func (n *SimulatedNetwork) Send(msg protocol.Message) {
-	// 1. Simulate Drop
-	if n.DropCheck() {
+	n.mu.Lock()
+	n.stats.Sent++
+	n.stats.ByType[msg.Type]++
+	n.mu.Unlock()
+
+	// 1. Simulate cut links, then random drops
+	if n.cut(msg) {
+		return
+	}
+	if n.dropCheck(msg.FromID, msg.ToID) {
+		n.mu.Lock()
+		n.stats.Dropped++
+		n.stats.DroppedByTx[msg.TransactionID]++
+		n.mu.Unlock()
 		log.Printf("[Network] DROPPED message %s from %s to %s", msg.Type, msg.FromID, msg.ToID)
 		return
 	}
 
 	// 2. Simulate Delay asynchronously
-	go func() {
-		delay := n.calculateDelay()
-		time.Sleep(delay)
-
+	delay := n.calculateDelay(msg.FromID, msg.ToID)
+	n.Clock.AfterFunc(delay, func() {
+		// A link cut while the message was in flight loses it
+		if n.cut(msg) {
+			return
+		}
 		n.mu.RLock()
 		ch, ok := n.nodes[msg.ToID]
 		n.mu.RUnlock()
@@ -79,9 +186,23 @@ func (n *SimulatedNetwork) Send(msg protocol.Message) {
 		} else {
 			log.Printf("[Network] Destination %s not found for message %s from %s", msg.ToID, msg.Type, msg.FromID)
 		}
-	}()
+	})
 }
 
+// cut reports whether msg travels over a cut link, counting it as blocked if so
+func (n *SimulatedNetwork) cut(msg protocol.Message) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.reachable(msg.FromID, msg.ToID) {
+		return false
+	}
+	n.stats.Blocked++
+	n.stats.DroppedByTx[msg.TransactionID]++
+	log.Printf("[Network] BLOCKED message %s from %s to %s", msg.Type, msg.FromID, msg.ToID)
+	return true
+}
+
+// DropCheck decides whether a message is lost at the network-wide DropRate
 func (n *SimulatedNetwork) DropCheck() bool {
 	// n.r is not safe for concurrent use, so we strictly speaking should lock it or use a per-goroutine source.
 	n.mu.Lock()
@@ -89,15 +210,22 @@ func (n *SimulatedNetwork) DropCheck() bool {
 	return n.r.Float64() < n.DropRate
 }
 
-func (n *SimulatedNetwork) calculateDelay() time.Duration {
-	// Simple uniform distribution: Delay +/- Jitter
-	jitterRange := float64(n.AverageDelay) * n.Jitter
-	// n.r.Float64 returns [0.0, 1.0)
-	// We want offset in [-jitterRange, +jitterRange)
+// dropCheck decides whether a message from -> to is lost at its link's rate
+func (n *SimulatedNetwork) dropCheck(from, to string) bool {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	offset := (n.r.Float64() * 2 * jitterRange) - jitterRange
-	delay := time.Duration(float64(n.AverageDelay) + offset)
+	return n.r.Float64() < n.link(from, to).DropRate
+}
+
+func (n *SimulatedNetwork) calculateDelay(from, to string) time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	cfg := n.link(from, to)
+	dist := cfg.Dist
+	if dist == nil {
+		dist = Uniform{Jitter: cfg.Jitter}
+	}
+	delay := dist.Sample(n.r, cfg.Delay)
 	if delay < 0 {
 		return 0
 	}
@@ -4,6 +4,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/clock"
 	"2pc-sim/pkg/protocol"
 )
 
@@ -61,9 +64,30 @@ func TestNetworkSend(t *testing.T) {
 	}
 }
 
+func TestNetworkDelay(t *testing.T) {
+	net := NewSimulatedNetwork(10*time.Millisecond, 0, 0)
+	clk := clock.NewFake(time.Time{})
+	net.Clock = clk
+	ch := make(chan protocol.Message, 1)
+	net.Register("receiver", ch)
+
+	net.Send(protocol.Message{Type: protocol.MsgPrepare, ToID: "receiver", FromID: "sender"})
+
+	clk.Advance(9 * time.Millisecond)
+	if len(ch) != 0 {
+		t.Fatal("Message arrived before its delay")
+	}
+	clk.Advance(time.Millisecond)
+	if len(ch) != 1 {
+		t.Fatal("Message should arrive once its delay has elapsed")
+	}
+}
+
 func TestNetworkDrop(t *testing.T) {
 	// 100% drop rate
 	net := NewSimulatedNetwork(0, 1.0, 0)
+	clk := clock.NewFake(time.Time{})
+	net.Clock = clk
 	ch := make(chan protocol.Message, 1)
 	id := "receiver"
 	net.Register(id, ch)
@@ -76,10 +100,31 @@ func TestNetworkDrop(t *testing.T) {
 
 	net.Send(msg)
 
-	select {
-	case <-ch:
+	if clk.Pending() != 0 {
+		t.Fatal("A dropped message should never be scheduled for delivery")
+	}
+	clk.Advance(time.Hour)
+	if len(ch) != 0 {
 		t.Fatal("Message should have been dropped")
-	case <-time.After(50 * time.Millisecond):
-		// Success: timeout means nothing arrived
+	}
+}
+
+func TestNetworkStats(t *testing.T) {
+	net := NewSimulatedNetwork(0, 1.0, 0)
+	txID := uuid.New()
+
+	net.Send(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, ToID: "a", FromID: "b"})
+	net.Send(protocol.Message{Type: protocol.MsgPrepare, TransactionID: txID, ToID: "a", FromID: "b"})
+	net.Send(protocol.Message{Type: protocol.MsgAck, ToID: "b", FromID: "a"})
+
+	stats := net.Stats()
+	if stats.Sent != 3 || stats.Dropped != 3 {
+		t.Errorf("Expected 3 sent and 3 dropped, got %+v", stats)
+	}
+	if stats.ByType[protocol.MsgPrepare] != 2 || stats.ByType[protocol.MsgAck] != 1 {
+		t.Errorf("Unexpected per-type counts %v", stats.ByType)
+	}
+	if stats.DroppedByTx[txID] != 2 {
+		t.Errorf("Expected 2 drops for the transaction, got %d", stats.DroppedByTx[txID])
 	}
 }
@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Topology gives links their own latency, jitter and loss, for layouts such as
+// several regions joined by WAN links. It is read from a JSON file:
+//
+//	{
+//	  "regions": {"us": ["coordinator", "p-0", "p-1"], "eu": ["p-2"]},
+//	  "links": [
+//	    {"from": "us", "to": "us", "latency_ms": 1, "jitter": 0.1},
+//	    {"from": "eu", "to": "eu", "latency_ms": 1, "jitter": 0.1},
+//	    {"from": "us", "to": "eu", "latency_ms": 40, "drop_rate": 0.01, "distribution": "lognormal:0.4"}
+//	  ]
+//	}
+type Topology struct {
+	// Regions name groups of nodes that links can refer to
+	Regions map[string][]string `json:"regions"`
+	// Links are applied in order, so a later entry overrides an earlier one
+	// for the node pairs they share
+	Links []TopologyLink `json:"links"`
+	dir   string         // where the file was, for relative histogram paths
+}
+
+// TopologyLink sets the links between two nodes or regions. It applies both
+// ways unless OneWay is set, and between the members of a region when From
+// and To are the same region.
+type TopologyLink struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	LatencyMs float64 `json:"latency_ms"`
+	Jitter    float64 `json:"jitter"`
+	DropRate  float64 `json:"drop_rate"`
+	// Distribution is as for ParseDistribution, with Jitter as the default
+	// spread. Empty keeps the network's Dist, parameter included, so Jitter
+	// then only counts if the network has none.
+	Distribution string `json:"distribution"`
+	OneWay       bool   `json:"one_way"`
+}
+
+// LoadTopology reads a topology file. Unknown fields are an error, so that a
+// misspelled setting is not silently left at zero.
+func LoadTopology(path string) (*Topology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t := Topology{dir: filepath.Dir(path)}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("topology %s: %w", path, err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("topology %s: unexpected data after the topology", path)
+	}
+	return &t, nil
+}
+
+// Apply sets the links of n between the given nodes. Every node named in the
+// topology must be one of them; pairs the topology leaves out keep the
+// network-wide settings.
+func (t *Topology) Apply(n *SimulatedNetwork, nodes []string) error {
+	known := make(map[string]bool)
+	for _, id := range nodes {
+		known[id] = true
+	}
+	for name, members := range t.Regions {
+		if known[name] {
+			return fmt.Errorf("region %q has the name of a node", name)
+		}
+		for _, id := range members {
+			if !known[id] {
+				return fmt.Errorf("region %q: unknown node %q", name, id)
+			}
+		}
+	}
+	resolve := func(name string) ([]string, error) {
+		if members, ok := t.Regions[name]; ok {
+			return members, nil
+		}
+		if known[name] {
+			return []string{name}, nil
+		}
+		return nil, fmt.Errorf("unknown node or region %q", name)
+	}
+
+	for i, l := range t.Links {
+		if l.LatencyMs < 0 || l.Jitter < 0 || l.Jitter > 1 || l.DropRate < 0 || l.DropRate > 1 {
+			return fmt.Errorf("link %d (%s-%s): latency must not be negative, and jitter and drop rate must be within 0-1", i, l.From, l.To)
+		}
+		from, err := resolve(l.From)
+		if err != nil {
+			return fmt.Errorf("link %d: %w", i, err)
+		}
+		to, err := resolve(l.To)
+		if err != nil {
+			return fmt.Errorf("link %d: %w", i, err)
+		}
+		cfg := LinkConfig{
+			Delay:    time.Duration(l.LatencyMs * float64(time.Millisecond)),
+			Jitter:   l.Jitter,
+			DropRate: l.DropRate,
+			Dist:     n.Dist,
+		}
+		if l.Distribution != "" {
+			spec := l.Distribution
+			if path, ok := strings.CutPrefix(spec, "empirical:"); ok && !filepath.IsAbs(path) && t.dir != "" {
+				spec = "empirical:" + filepath.Join(t.dir, path)
+			}
+			if cfg.Dist, err = ParseDistribution(spec, l.Jitter); err != nil {
+				return fmt.Errorf("link %d: %w", i, err)
+			}
+		}
+		for _, a := range from {
+			for _, b := range to {
+				if a == b {
+					continue
+				}
+				n.SetLink(a, b, cfg)
+				if !l.OneWay {
+					n.SetLink(b, a, cfg)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RegionOf returns the region each node belongs to, in region name order
+func (t *Topology) RegionOf() map[string]string {
+	names := make([]string, 0, len(t.Regions))
+	for name := range t.Regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make(map[string]string)
+	for _, name := range names {
+		for _, id := range t.Regions[name] {
+			if _, ok := out[id]; !ok {
+				out[id] = name
+			}
+		}
+	}
+	return out
+}
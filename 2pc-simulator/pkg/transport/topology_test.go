@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"2pc-sim/pkg/clock"
+	"2pc-sim/pkg/protocol"
+)
+
+var topologyNodes = []string{"coord", "p1", "p2", "p3"}
+
+func TestTopologyApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topology.json")
+	if err := os.WriteFile(path, []byte(`{
+		"regions": {"us": ["coord", "p1", "p2"], "eu": ["p3"]},
+		"links": [
+			{"from": "us", "to": "us", "latency_ms": 1, "jitter": 0.1},
+			{"from": "us", "to": "eu", "latency_ms": 40, "drop_rate": 0.01},
+			{"from": "p3", "to": "p2", "latency_ms": 60, "one_way": true}
+		]
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	topology, err := LoadTopology(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	net := NewSimulatedNetwork(10*time.Millisecond, 0, 0.2)
+	if err := topology.Apply(net, topologyNodes); err != nil {
+		t.Fatal(err)
+	}
+
+	lan := LinkConfig{Delay: time.Millisecond, Jitter: 0.1}
+	wan := LinkConfig{Delay: 40 * time.Millisecond, DropRate: 0.01}
+	for _, l := range []struct {
+		from, to string
+		want     LinkConfig
+	}{
+		{"coord", "p1", lan},
+		{"p2", "coord", lan},
+		{"coord", "p3", wan},
+		{"p3", "coord", wan},
+		{"p2", "p3", wan},
+		// A later, one-way entry overrides one direction only
+		{"p3", "p2", LinkConfig{Delay: 60 * time.Millisecond}},
+		// Pairs the topology leaves out keep the network's settings
+		{"p3", "outsider", LinkConfig{Delay: 10 * time.Millisecond, Jitter: 0.2}},
+	} {
+		if got := net.Link(l.from, l.to); got != l.want {
+			t.Errorf("Link(%s, %s) = %+v, want %+v", l.from, l.to, got, l.want)
+		}
+	}
+
+	regions := topology.RegionOf()
+	if regions["p1"] != "us" || regions["p3"] != "eu" {
+		t.Errorf("Unexpected regions %v", regions)
+	}
+}
+
+func TestTopologyDistributions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wan.txt"), []byte("50 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "topology.json")
+	if err := os.WriteFile(path, []byte(`{
+		"links": [
+			{"from": "coord", "to": "p1", "latency_ms": 5, "jitter": 0.3, "distribution": "normal"},
+			{"from": "coord", "to": "p2", "distribution": "empirical:wan.txt"}
+		]
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	topology, err := LoadTopology(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	net := NewSimulatedNetwork(10*time.Millisecond, 0, 0)
+	net.Dist = Exponential{}
+	if err := topology.Apply(net, topologyNodes); err != nil {
+		t.Fatal(err)
+	}
+
+	// The link's jitter is the spread of its normal distribution
+	if got := net.Link("p1", "coord").Dist; got != (Normal{StdDev: 0.3}) {
+		t.Errorf("Expected normal:0.3, got %v", got)
+	}
+	// The histogram is found next to the topology file
+	if got := net.Link("coord", "p2").Dist; got == nil || got.String() != "empirical:"+filepath.Join(dir, "wan.txt") {
+		t.Errorf("Expected the histogram beside the topology, got %v", got)
+	}
+	// Other links keep the network's distribution
+	if got := net.Link("p1", "p2").Dist; got != (Exponential{}) {
+		t.Errorf("Expected the network's exponential, got %v", got)
+	}
+
+	bad := Topology{Links: []TopologyLink{{From: "coord", To: "p1", Distribution: "gamma"}}}
+	if err := bad.Apply(net, topologyNodes); err == nil {
+		t.Error("Expected an error for an unknown distribution")
+	}
+}
+
+func TestTopologyRejectsUnknownNames(t *testing.T) {
+	for name, topology := range map[string]Topology{
+		"member":  {Regions: map[string][]string{"us": {"p9"}}},
+		"link":    {Links: []TopologyLink{{From: "coord", To: "mars"}}},
+		"clash":   {Regions: map[string][]string{"p1": {"p2"}}},
+		"jitter":  {Links: []TopologyLink{{From: "coord", To: "p1", Jitter: 2}}},
+		"latency": {Links: []TopologyLink{{From: "coord", To: "p1", LatencyMs: -1}}},
+	} {
+		if err := topology.Apply(NewSimulatedNetwork(0, 0, 0), topologyNodes); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadTopologyRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topology.json")
+	if err := os.WriteFile(path, []byte(`{
+		"links": [{"from": "coord", "to": "p1", "latency_ms": 5, "jiter": 0.3}]
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTopology(path); err == nil || !strings.Contains(err.Error(), "jiter") {
+		t.Errorf("Expected an error naming the misspelled field, got %v", err)
+	}
+}
+
+func TestLinkSettingsApplyToMessages(t *testing.T) {
+	net := NewSimulatedNetwork(10*time.Millisecond, 0, 0)
+	clk := clock.NewFake(time.Time{})
+	net.Clock = clk
+	net.SetLink("coord", "far", LinkConfig{Delay: 40 * time.Millisecond})
+	net.SetLink("coord", "lossy", LinkConfig{DropRate: 1})
+	near, far, lossy := make(chan protocol.Message, 1), make(chan protocol.Message, 1), make(chan protocol.Message, 1)
+	net.Register("near", near)
+	net.Register("far", far)
+	net.Register("lossy", lossy)
+
+	for _, to := range []string{"near", "far", "lossy"} {
+		net.Send(protocol.Message{Type: protocol.MsgPrepare, FromID: "coord", ToID: to})
+	}
+	clk.Advance(10 * time.Millisecond)
+	if len(near) != 1 || len(far) != 0 {
+		t.Fatal("Only the near node should have its message after 10ms")
+	}
+	clk.Advance(30 * time.Millisecond)
+	if len(far) != 1 {
+		t.Error("The far node should have its message after 40ms")
+	}
+	if len(lossy) != 0 || net.Stats().Dropped != 1 {
+		t.Error("The lossy link should drop its message")
+	}
+}
@@ -0,0 +1,75 @@
+package wal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/storage"
+)
+
+func TestFileLogReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p1.wal")
+	txID := uuid.New()
+
+	l, err := OpenFileLog(path, 0)
+	if err != nil {
+		t.Fatalf("OpenFileLog failed: %v", err)
+	}
+	writes := []storage.Write{{Key: "a", Value: "1"}, {Key: "b", Delete: true}}
+	l.Append(Record{Type: RecPrepared, TransactionID: txID, Coordinator: "coord", Writes: writes}, true)
+	l.Append(Record{Type: RecCommit, TransactionID: txID}, true)
+	if got := l.Stats().Forces; got != 2 {
+		t.Errorf("Expected 2 forces, got %d", got)
+	}
+	l.Close()
+
+	// Reopening must keep the existing records
+	l, err = OpenFileLog(path, 0)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer l.Close()
+
+	records, err := l.Records()
+	if err != nil {
+		t.Fatalf("Records failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if records[0].TransactionID != txID || records[0].Coordinator != "coord" || len(records[0].Writes) != 2 || !records[0].Writes[1].Delete {
+		t.Errorf("Record did not round-trip: %+v", records[0])
+	}
+	if records[1].Type != RecCommit {
+		t.Errorf("Expected Commit record, got %s", records[1].Type)
+	}
+}
+
+func TestFileLogLargeRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p1.wal")
+	l, err := OpenFileLog(path, 0)
+	if err != nil {
+		t.Fatalf("OpenFileLog failed: %v", err)
+	}
+	defer l.Close()
+
+	// Well past bufio.Scanner's 64 KiB line limit
+	var writes []storage.Write
+	for i := 0; i < 20; i++ {
+		writes = append(writes, storage.Write{Key: fmt.Sprintf("k%d", i), Value: strings.Repeat("x", 10000)})
+	}
+	l.Append(Record{Type: RecPrepared, TransactionID: uuid.New(), Writes: writes}, true)
+	l.Append(Record{Type: RecCommit, TransactionID: uuid.New()}, true)
+
+	records, err := l.Records()
+	if err != nil {
+		t.Fatalf("Records failed: %v", err)
+	}
+	if len(records) != 2 || len(records[0].Writes) != 20 {
+		t.Fatalf("Expected both records with 20 writes, got %d records", len(records))
+	}
+}
@@ -0,0 +1,121 @@
+package wal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/storage"
+)
+
+// RecordType identifies what a log record describes
+type RecordType int
+
+const (
+	RecPrepared RecordType = iota
+	RecCommit
+	RecAbort
+	RecEnd
+	RecBegin
+	RecPreCommitted
+	RecReadOnly
+)
+
+func (r RecordType) String() string {
+	switch r {
+	case RecPrepared:
+		return "Prepared"
+	case RecCommit:
+		return "Commit"
+	case RecAbort:
+		return "Abort"
+	case RecEnd:
+		return "End"
+	case RecBegin:
+		return "Begin"
+	case RecPreCommitted:
+		return "PreCommitted"
+	case RecReadOnly:
+		return "ReadOnly"
+	default:
+		return "Unknown"
+	}
+}
+
+// Record is a single entry in a node's write-ahead log
+type Record struct {
+	Type          RecordType
+	TransactionID uuid.UUID
+	// Coordinator is written by participants so in-doubt transactions know whom to ask
+	Coordinator string `json:",omitempty"`
+	// Participants is written by the coordinator so it can resume Phase 2
+	Participants []string `json:",omitempty"`
+	// Writes is the write set in a participant's Prepared record, redone on recovery
+	Writes []storage.Write `json:",omitempty"`
+}
+
+// Stats summarises the logging work done by a Log
+type Stats struct {
+	Appends  int
+	Forces   int
+	SyncTime time.Duration
+}
+
+// Log is an append-only write-ahead log
+type Log interface {
+	// Append adds rec to the log. If force is true it returns only once rec
+	// (and everything appended before it) is durable.
+	Append(rec Record, force bool) error
+	// Records returns every record appended so far, oldest first
+	Records() ([]Record, error)
+	Stats() Stats
+	Close() error
+}
+
+// MemoryLog is an in-memory Log. Forced appends sleep for SyncDelay to model
+// the cost of an fsync without touching the disk.
+type MemoryLog struct {
+	mu        sync.Mutex
+	records   []Record
+	stats     Stats
+	SyncDelay time.Duration
+}
+
+// NewMemoryLog creates an empty in-memory log
+func NewMemoryLog(syncDelay time.Duration) *MemoryLog {
+	return &MemoryLog{SyncDelay: syncDelay}
+}
+
+func (l *MemoryLog) Append(rec Record, force bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, rec)
+	l.stats.Appends++
+	if force {
+		start := time.Now()
+		time.Sleep(l.SyncDelay)
+		l.stats.Forces++
+		l.stats.SyncTime += time.Since(start)
+	}
+	return nil
+}
+
+func (l *MemoryLog) Records() ([]Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Record, len(l.records))
+	copy(out, l.records)
+	return out, nil
+}
+
+func (l *MemoryLog) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func (l *MemoryLog) Close() error {
+	return nil
+}
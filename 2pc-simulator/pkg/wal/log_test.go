@@ -0,0 +1,66 @@
+package wal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryLogAppend(t *testing.T) {
+	l := NewMemoryLog(0)
+	txID := uuid.New()
+
+	if err := l.Append(Record{Type: RecPrepared, TransactionID: txID}, true); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := l.Append(Record{Type: RecEnd, TransactionID: txID}, false); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	records, _ := l.Records()
+	if len(records) != 2 || records[0].Type != RecPrepared || records[1].Type != RecEnd {
+		t.Errorf("Unexpected records %v", records)
+	}
+
+	stats := l.Stats()
+	if stats.Appends != 2 || stats.Forces != 1 {
+		t.Errorf("Expected 2 appends and 1 force, got %+v", stats)
+	}
+}
+
+func TestMemoryLogSyncDelay(t *testing.T) {
+	l := NewMemoryLog(20 * time.Millisecond)
+
+	l.Append(Record{Type: RecCommit, TransactionID: uuid.New()}, false)
+	if l.Stats().SyncTime != 0 {
+		t.Errorf("Unforced append should not pay the sync delay")
+	}
+
+	l.Append(Record{Type: RecCommit, TransactionID: uuid.New()}, true)
+	if got := l.Stats().SyncTime; got < 20*time.Millisecond {
+		t.Errorf("Expected SyncTime >= 20ms, got %v", got)
+	}
+}
+
+func TestRecordTypeString(t *testing.T) {
+	tests := []struct {
+		recType  RecordType
+		expected string
+	}{
+		{RecPrepared, "Prepared"},
+		{RecCommit, "Commit"},
+		{RecAbort, "Abort"},
+		{RecEnd, "End"},
+		{RecBegin, "Begin"},
+		{RecPreCommitted, "PreCommitted"},
+		{RecReadOnly, "ReadOnly"},
+		{RecordType(999), "Unknown"},
+	}
+
+	for _, tc := range tests {
+		if got := tc.recType.String(); got != tc.expected {
+			t.Errorf("RecordType(%d).String() = %s; want %s", tc.recType, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"2pc-sim/pkg/node"
+	"2pc-sim/pkg/protocol"
+	"2pc-sim/pkg/sim"
+)
+
+// Config describes a workload: how many transactions, how they arrive and
+// what each of them does
+type Config struct {
+	Transactions int
+	// Concurrency is the number of closed-loop clients, each starting a new
+	// transaction as soon as its last one finished. 0 starts everything at once.
+	Concurrency int
+	// Rate switches to an open loop: transactions arrive as a Poisson process
+	// with this many arrivals per second, whether or not earlier ones finished
+	Rate float64
+	// Keys is the size of the key space; 0 runs transactions without operations
+	Keys int
+	// Ops is the number of operations in each transaction
+	Ops int
+	// Participants is how many participants each transaction touches; 0 lets
+	// the keys fall where they may
+	Participants int
+	// ReadRatio is the fraction of operations that are Gets rather than Puts
+	ReadRatio float64
+	// Zipf skews key popularity with exponent s > 1; 0 picks keys uniformly
+	Zipf float64
+	Seed int64
+}
+
+// Generator builds the operations of each transaction
+type Generator struct {
+	cfg     Config
+	mu      sync.Mutex
+	rng     *rand.Rand
+	keys    []string
+	owners  []string            // participants owning at least one key
+	byOwner map[string][]string // keys of each participant, in key order
+	zipf    map[string]*rand.Zipf
+	all     *rand.Zipf
+	count   int
+}
+
+// NewGenerator prepares a generator for keys, which owner maps to participants
+func NewGenerator(cfg Config, keys []string, owner func(key string) string) (*Generator, error) {
+	if cfg.Zipf != 0 && cfg.Zipf <= 1 {
+		return nil, fmt.Errorf("zipf exponent must be greater than 1, got %v", cfg.Zipf)
+	}
+	if cfg.ReadRatio < 0 || cfg.ReadRatio > 1 {
+		return nil, fmt.Errorf("read ratio must be between 0 and 1, got %v", cfg.ReadRatio)
+	}
+	g := &Generator{
+		cfg:     cfg,
+		rng:     rand.New(rand.NewSource(cfg.Seed)),
+		keys:    keys,
+		byOwner: make(map[string][]string),
+		zipf:    make(map[string]*rand.Zipf),
+	}
+	for _, k := range keys {
+		o := owner(k)
+		if _, ok := g.byOwner[o]; !ok {
+			g.owners = append(g.owners, o)
+		}
+		g.byOwner[o] = append(g.byOwner[o], k)
+	}
+	if cfg.Participants > len(g.owners) {
+		return nil, fmt.Errorf("transactions cannot touch %d participants when only %d own keys", cfg.Participants, len(g.owners))
+	}
+	if cfg.Participants > 0 && cfg.Ops < cfg.Participants {
+		return nil, fmt.Errorf("%d operations cannot touch %d participants", cfg.Ops, cfg.Participants)
+	}
+	if cfg.Zipf > 1 && len(keys) > 0 {
+		g.all = rand.NewZipf(g.rng, cfg.Zipf, 1, uint64(len(keys)-1))
+		for o, ks := range g.byOwner {
+			g.zipf[o] = rand.NewZipf(g.rng, cfg.Zipf, 1, uint64(len(ks)-1))
+		}
+	}
+	return g, nil
+}
+
+// pick draws a key from keys; with skew, the first keys are the hottest
+func (g *Generator) pick(keys []string, z *rand.Zipf) string {
+	if z != nil {
+		return keys[z.Uint64()]
+	}
+	return keys[g.rng.Intn(len(keys))]
+}
+
+// Next returns the operations of the next transaction, or nil when the
+// workload has no key space
+func (g *Generator) Next() []protocol.Operation {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.keys) == 0 {
+		return nil
+	}
+	g.count++
+
+	// Choose which participants to touch, then spread the operations over them
+	var targets []string
+	if g.cfg.Participants > 0 {
+		for _, i := range g.rng.Perm(len(g.owners))[:g.cfg.Participants] {
+			targets = append(targets, g.owners[i])
+		}
+	}
+
+	ops := make([]protocol.Operation, g.cfg.Ops)
+	for i := range ops {
+		key := ""
+		if targets == nil {
+			key = g.pick(g.keys, g.all)
+		} else {
+			o := targets[i%len(targets)]
+			key = g.pick(g.byOwner[o], g.zipf[o])
+		}
+		if g.rng.Float64() < g.cfg.ReadRatio {
+			ops[i] = protocol.Operation{Type: protocol.OpGet, Key: key}
+		} else {
+			ops[i] = protocol.Operation{Type: protocol.OpPut, Key: key, Value: fmt.Sprintf("tx-%d", g.count)}
+		}
+	}
+	return ops
+}
+
+// interarrival draws the gap before the next open-loop arrival
+func (g *Generator) interarrival() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return time.Duration(g.rng.ExpFloat64() / g.cfg.Rate * float64(time.Second))
+}
+
+// Run starts the workload's transactions with begin and waits for all of them
+// to finish. It returns them in the order they were started.
+func (g *Generator) Run(begin func(ops []protocol.Operation) *node.Txn) []*node.Txn {
+	var mu sync.Mutex
+	var txns []*node.Txn
+	start := func() *node.Txn {
+		tx := begin(g.Next())
+		mu.Lock()
+		txns = append(txns, tx)
+		mu.Unlock()
+		return tx
+	}
+
+	switch {
+	case g.cfg.Rate > 0:
+		// Open loop: arrivals do not wait for earlier transactions
+		for i := 0; i < g.cfg.Transactions; i++ {
+			if i > 0 {
+				time.Sleep(g.interarrival())
+			}
+			start()
+		}
+	case g.cfg.Concurrency > 0 && g.cfg.Concurrency < g.cfg.Transactions:
+		// Closed loop: each client runs one transaction at a time
+		remaining := g.cfg.Transactions
+		var wg sync.WaitGroup
+		for c := 0; c < g.cfg.Concurrency; c++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					mu.Lock()
+					if remaining == 0 {
+						mu.Unlock()
+						return
+					}
+					remaining--
+					mu.Unlock()
+					start().Wait()
+				}
+			}()
+		}
+		wg.Wait()
+	default:
+		for i := 0; i < g.cfg.Transactions; i++ {
+			start()
+		}
+	}
+
+	for _, tx := range txns {
+		tx.Wait()
+	}
+	return txns
+}
+
+// RunOn is Run for a discrete-event simulation: arrivals are events on s, and
+// closed-loop clients start their next transaction as soon as the event that
+// finished the last one has run. It drives s until every transaction has
+// finished, or until s runs out of events.
+func (g *Generator) RunOn(s *sim.Scheduler, begin func(ops []protocol.Operation) *node.Txn) []*node.Txn {
+	var txns []*node.Txn
+	start := func() *node.Txn {
+		tx := begin(g.Next())
+		txns = append(txns, tx)
+		return tx
+	}
+
+	switch {
+	case g.cfg.Rate > 0:
+		var at time.Duration
+		for i := 0; i < g.cfg.Transactions; i++ {
+			if i > 0 {
+				at += g.interarrival()
+			}
+			s.AfterFunc(at, func() { start() })
+		}
+	case g.cfg.Concurrency > 0 && g.cfg.Concurrency < g.cfg.Transactions:
+		remaining := g.cfg.Transactions
+		clients := make([]*node.Txn, g.cfg.Concurrency)
+		for c := range clients {
+			clients[c] = start()
+			remaining--
+		}
+		stop := make(chan struct{})
+		defer close(stop)
+		s.Serve(stop, func() bool {
+			progress := false
+			for c, tx := range clients {
+				if tx == nil {
+					continue
+				}
+				select {
+				case <-tx.Done():
+					clients[c] = nil
+					if remaining > 0 {
+						clients[c] = start()
+						remaining--
+					}
+					progress = true
+				default:
+				}
+			}
+			return progress
+		})
+	default:
+		for i := 0; i < g.cfg.Transactions; i++ {
+			start()
+		}
+	}
+
+	finished := 0
+	s.RunUntil(func() bool {
+		for finished < len(txns) {
+			select {
+			case <-txns[finished].Done():
+				finished++
+			default:
+				return false
+			}
+		}
+		return finished == g.cfg.Transactions
+	})
+	return txns
+}
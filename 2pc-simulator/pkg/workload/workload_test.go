@@ -0,0 +1,234 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"2pc-sim/pkg/node"
+	"2pc-sim/pkg/protocol"
+	"2pc-sim/pkg/sim"
+	"2pc-sim/pkg/transport"
+)
+
+func keySpace(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%03d", i)
+	}
+	return keys
+}
+
+// byHundreds gives p-0 keys 0-99, p-1 keys 100-199 and so on
+func byHundreds(key string) string {
+	return "p-" + key[4:5]
+}
+
+func TestGeneratorShapesTransactions(t *testing.T) {
+	g, err := NewGenerator(Config{Keys: 300, Ops: 4, Participants: 2, ReadRatio: 0.5, Seed: 1}, keySpace(300), byHundreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reads, total := 0, 0
+	for i := 0; i < 200; i++ {
+		ops := g.Next()
+		if len(ops) != 4 {
+			t.Fatalf("Expected 4 operations, got %d", len(ops))
+		}
+		owners := make(map[string]bool)
+		for _, op := range ops {
+			owners[byHundreds(op.Key)] = true
+			if op.Type == protocol.OpGet {
+				reads++
+			}
+			total++
+		}
+		if len(owners) != 2 {
+			t.Fatalf("Expected exactly 2 participants, got %v", owners)
+		}
+	}
+	if ratio := float64(reads) / float64(total); ratio < 0.4 || ratio > 0.6 {
+		t.Errorf("Expected about half the operations to be reads, got %.2f", ratio)
+	}
+}
+
+func TestGeneratorZipfSkew(t *testing.T) {
+	keys := keySpace(100)
+	uniform, _ := NewGenerator(Config{Keys: 100, Ops: 1, Seed: 1}, keys, byHundreds)
+	skewed, err := NewGenerator(Config{Keys: 100, Ops: 1, Zipf: 1.5, Seed: 1}, keys, byHundreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hot := func(g *Generator) int {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if g.Next()[0].Key == keys[0] {
+				n++
+			}
+		}
+		return n
+	}
+	if u, s := hot(uniform), hot(skewed); s < 5*u {
+		t.Errorf("Expected the hottest key to dominate under Zipf: uniform %d, zipf %d", u, s)
+	}
+}
+
+func TestGeneratorRejectsBadConfig(t *testing.T) {
+	keys := keySpace(300)
+	for _, cfg := range []Config{
+		{Keys: 300, Ops: 1, Zipf: 0.5},
+		{Keys: 300, Ops: 1, ReadRatio: 2},
+		{Keys: 300, Ops: 4, Participants: 4},
+		{Keys: 300, Ops: 1, Participants: 2},
+	} {
+		if _, err := NewGenerator(cfg, keys, byHundreds); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
+
+// cluster starts a coordinator with two participants on a fast network
+func cluster(t *testing.T) *node.Coordinator {
+	net := transport.NewSimulatedNetwork(time.Millisecond, 0, 0)
+	var ids []string
+	for i := 0; i < 2; i++ {
+		p := node.NewParticipant(fmt.Sprintf("p-%d", i), net, "coord")
+		p.Start()
+		ids = append(ids, p.ID)
+	}
+	c := node.NewCoordinator("coord", net, ids, time.Second, 100*time.Millisecond)
+	c.Start()
+	t.Cleanup(c.Crash)
+	return c
+}
+
+func TestRunClosedLoopLimitsConcurrency(t *testing.T) {
+	c := cluster(t)
+	g, _ := NewGenerator(Config{Transactions: 20, Concurrency: 3}, nil, byHundreds)
+
+	// A client only starts a transaction once its last one is done, so at
+	// most two others can be unfinished when one starts
+	var mu sync.Mutex
+	var started []*node.Txn
+	peak := 0
+	txns := g.Run(func(ops []protocol.Operation) *node.Txn {
+		mu.Lock()
+		defer mu.Unlock()
+		unfinished := 1
+		for _, tx := range started {
+			select {
+			case <-tx.Done():
+			default:
+				unfinished++
+			}
+		}
+		if unfinished > peak {
+			peak = unfinished
+		}
+		tx := c.BeginOps(ops)
+		started = append(started, tx)
+		return tx
+	})
+
+	if len(txns) != 20 {
+		t.Fatalf("Expected 20 transactions, got %d", len(txns))
+	}
+	for _, tx := range txns {
+		if committed, _ := tx.Wait(); !committed {
+			t.Error("Expected every transaction to commit")
+		}
+	}
+	if peak > 3 {
+		t.Errorf("Expected at most 3 transactions in flight, got %d", peak)
+	}
+}
+
+func TestRunOpenLoopPacesArrivals(t *testing.T) {
+	c := cluster(t)
+	g, _ := NewGenerator(Config{Transactions: 10, Rate: 200, Seed: 1}, nil, byHundreds)
+
+	start := time.Now()
+	txns := g.Run(c.BeginOps)
+	if len(txns) != 10 {
+		t.Fatalf("Expected 10 transactions, got %d", len(txns))
+	}
+	// Nine gaps averaging 5ms; allow plenty of slack for an unlucky draw
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond {
+		t.Errorf("Open-loop arrivals should be spread out, all done in %v", elapsed)
+	}
+}
+
+// simulatedCluster builds the cluster on a lossy network driven by a scheduler
+// seeded with seed, with transaction IDs drawn from the seed too
+func simulatedCluster(t *testing.T, seed int64, dropRate float64, timeout time.Duration) (*sim.Scheduler, *node.Coordinator, *transport.SimulatedNetwork) {
+	uuid.SetRand(rand.New(rand.NewSource(seed)))
+	t.Cleanup(func() { uuid.SetRand(nil) })
+	s := sim.NewScheduler(seed)
+	net := transport.NewSimulatedNetwork(10*time.Millisecond, dropRate, 0.5)
+	net.Clock = s
+	net.Seed(seed)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		p := node.NewParticipant(fmt.Sprintf("p-%d", i), net, "coord")
+		p.Clock = s
+		p.Locks.Clock = s
+		p.Start()
+		ids = append(ids, p.ID)
+	}
+	c := node.NewCoordinator("coord", net, ids, timeout, 50*time.Millisecond)
+	c.Clock = s
+	c.Start()
+	return s, c, net
+}
+
+func TestRunOnIsReproducible(t *testing.T) {
+	run := func(seed int64) string {
+		s, c, net := simulatedCluster(t, seed, 0.1, time.Second)
+		g, _ := NewGenerator(Config{Transactions: 30, Concurrency: 4, Keys: 30, Ops: 2, Seed: seed}, keySpace(30), func(key string) string {
+			return fmt.Sprintf("p-%d", int(key[6]-'0')%3)
+		})
+		c.Owner = func(key string) string { return fmt.Sprintf("p-%d", int(key[6]-'0')%3) }
+		trace := ""
+		for _, tx := range g.RunOn(s, c.BeginOps) {
+			committed, latency := tx.Wait()
+			trace += fmt.Sprintf("%s %v %v %d\n", tx.ID, committed, latency, tx.Retries)
+		}
+		stats := net.Stats()
+		return trace + fmt.Sprintf("%v sent %d dropped %d", s.Now(), stats.Sent, stats.Dropped)
+	}
+
+	first := run(7)
+	if again := run(7); again != first {
+		t.Errorf("The same seed should replay the same run:\n%s\nthen\n%s", first, again)
+	}
+	if other := run(8); other == first {
+		t.Error("Another seed should give another run")
+	}
+}
+
+func TestRunOnSkipsTheWait(t *testing.T) {
+	// Every message is lost, so each transaction waits out two 10s timeouts:
+	// one for the votes and one for the Acks of the abort
+	s, c, _ := simulatedCluster(t, 1, 1.0, 10*time.Second)
+	g, _ := NewGenerator(Config{Transactions: 5, Rate: 1, Seed: 1}, nil, byHundreds)
+
+	start := time.Now()
+	txns := g.RunOn(s, c.BeginOps)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Virtual timeouts should not take real time, took %v", elapsed)
+	}
+	if len(txns) != 5 {
+		t.Fatalf("Expected 5 transactions, got %d", len(txns))
+	}
+	for _, tx := range txns {
+		if committed, latency := tx.Wait(); committed || latency != 20*time.Second {
+			t.Errorf("Expected an abort after exactly 20s of virtual time, got %v after %v", committed, latency)
+		}
+	}
+}